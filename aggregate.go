@@ -0,0 +1,278 @@
+package borm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+// aggregate functions
+const (
+	aggCount = "Count"
+	aggSum   = "Sum"
+	aggAvg   = "Avg"
+	aggMin   = "Min"
+	aggMax   = "Max"
+)
+
+// Aggregation is a query computing aggregates of bucket records.
+// Fields are referenced by storage names
+// 		type Total struct {
+// 			Status    string
+// 			SumAmount float64
+// 			Count     int
+// 		}
+// 		res := []Total{}
+// 		db.Aggregate([]string{"orders"}).GroupBy("Status").Sum("Amount").Count().Run(&res)
+type Aggregation struct {
+	db      *DB
+	path    []string
+	groupBy []string
+	aggs    []aggregate
+	conds   []Cond
+}
+
+type aggregate struct {
+	fn    string
+	field string
+	name  string
+}
+
+// aggState is accumulated value of aggregate in group
+type aggState struct {
+	count int64
+	sum   float64
+	min   float64
+	max   float64
+	// n is number of numeric values
+	n int64
+}
+
+// Aggregate returns aggregation query of bucket records
+func (db *DB) Aggregate(path []string) *Aggregation {
+	path = db.scope(path)
+	return &Aggregation{db: db, path: path}
+}
+
+// GroupBy groups records by values of fields
+func (a *Aggregation) GroupBy(fields ...string) *Aggregation {
+	a.groupBy = append(a.groupBy, fields...)
+	return a
+}
+
+// Where filters aggregated records
+// 		db.Aggregate(path).Where("Amount", ">", 100).Count()
+func (a *Aggregation) Where(field, op string, value interface{}) *Aggregation {
+	a.conds = append(a.conds, Cond{Field: field, Op: op, Value: value})
+	return a
+}
+
+// Count counts records in group. Result is named Count
+func (a *Aggregation) Count() *Aggregation {
+	return a.add(aggCount, "")
+}
+
+// Sum sums field values. Result is named Sum<field>
+func (a *Aggregation) Sum(field string) *Aggregation {
+	return a.add(aggSum, field)
+}
+
+// Avg computes average of field values. Result is named Avg<field>
+func (a *Aggregation) Avg(field string) *Aggregation {
+	return a.add(aggAvg, field)
+}
+
+// Min computes minimum of field values. Result is named Min<field>
+func (a *Aggregation) Min(field string) *Aggregation {
+	return a.add(aggMin, field)
+}
+
+// Max computes maximum of field values. Result is named Max<field>
+func (a *Aggregation) Max(field string) *Aggregation {
+	return a.add(aggMax, field)
+}
+
+// As renames result of the last aggregate
+// 		db.Aggregate(path).Sum("Amount").As("Total")
+func (a *Aggregation) As(name string) *Aggregation {
+	if len(a.aggs) > 0 {
+		a.aggs[len(a.aggs)-1].name = name
+	}
+	return a
+}
+
+func (a *Aggregation) add(fn, field string) *Aggregation {
+	a.aggs = append(a.aggs, aggregate{fn: fn, field: field, name: fn + field})
+	return a
+}
+
+// Run computes aggregates and fills out with results. out is a pointer to
+// slice of structs or maps having group fields and aggregates as fields.
+// Groups are ordered by group field values
+func (a *Aggregation) Run(out interface{}) error {
+	l := logit(a.db.Log, "AGGREGATE", a.path, "", a.groupBy)
+	err := a.run(out)
+	return l.done(err)
+}
+
+func (a *Aggregation) run(out interface{}) error {
+	if err := a.db.check(a.path); err != nil {
+		return err
+	}
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return errors.New("expected pointer to slice")
+	}
+
+	fields := append(append([]string{}, a.groupBy...), condFields(a.conds)...)
+	for _, ag := range a.aggs {
+		if ag.field != "" {
+			fields = appendUnique(fields, ag.field)
+		}
+	}
+
+	groups := make(map[string][]interface{})
+	states := make(map[string][]aggState)
+	err := a.db.db.View(func(tx *bolt.Tx) error {
+		b := getBucket(tx, a.path)
+		if b == nil {
+			return a.db.bucketNotFound(tx, a.path)
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if v == nil {
+				continue
+			}
+			rec := map[string]interface{}{}
+			if len(fields) > 0 {
+				v, err := a.db.unpack(a.path, v)
+				if err != nil {
+					return err
+				}
+				if rec, err = decodeFields(v, fields); err != nil {
+					return fmt.Errorf("could not decode %s: %s", k, err)
+				}
+			}
+			ok, err := matchConds(rec, a.conds)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+
+			vals := make([]interface{}, len(a.groupBy))
+			for i, f := range a.groupBy {
+				vals[i] = rec[f]
+			}
+			enc, err := marshal(vals)
+			if err != nil {
+				return err
+			}
+			key := string(enc)
+			st, ok := states[key]
+			if !ok {
+				groups[key] = vals
+				st = make([]aggState, len(a.aggs))
+			}
+			for i, ag := range a.aggs {
+				st[i].add(rec[ag.field])
+			}
+			states[key] = st
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return lessGroup(groups[keys[i]], groups[keys[j]], keys[i], keys[j])
+	})
+
+	rows := make([]map[string]interface{}, 0, len(keys))
+	for _, k := range keys {
+		row := make(map[string]interface{})
+		for i, f := range a.groupBy {
+			row[f] = groups[k][i]
+		}
+		for i, ag := range a.aggs {
+			row[ag.name] = states[k][i].result(ag.fn)
+		}
+		rows = append(rows, row)
+	}
+
+	enc, err := marshal(rows)
+	if err != nil {
+		return err
+	}
+	return unmarshal(enc, out)
+}
+
+// lessGroup orders groups by values of group fields compared as in
+// conditions. Groups with values that can't be compared are ordered by
+// their keys ka and kb
+func lessGroup(a, b []interface{}, ka, kb string) bool {
+	for i := range a {
+		c, err := compareValues(a[i], b[i])
+		if err != nil {
+			break
+		}
+		if c == 0 {
+			continue
+		}
+		if r, _ := compareValues(b[i], a[i]); r == -c {
+			return c < 0
+		}
+		break
+	}
+	return ka < kb
+}
+
+func (s *aggState) add(v interface{}) {
+	s.count++
+	f, ok := toFloat(v)
+	if !ok {
+		return
+	}
+	if s.n == 0 || f < s.min {
+		s.min = f
+	}
+	if s.n == 0 || f > s.max {
+		s.max = f
+	}
+	s.sum += f
+	s.n++
+}
+
+func (s *aggState) result(fn string) interface{} {
+	switch fn {
+	case aggCount:
+		return s.count
+	case aggSum:
+		return s.sum
+	case aggAvg:
+		if s.n == 0 {
+			return nil
+		}
+		return s.sum / float64(s.n)
+	case aggMin:
+		if s.n == 0 {
+			return nil
+		}
+		return s.min
+	case aggMax:
+		if s.n == 0 {
+			return nil
+		}
+		return s.max
+	}
+	return nil
+}
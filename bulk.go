@@ -0,0 +1,141 @@
+package borm
+
+import (
+	"bytes"
+	"reflect"
+
+	"github.com/boltdb/bolt"
+)
+
+// deleteBatch is number of records deleted in single transaction
+const deleteBatch = 1000
+
+// DeleteRange deletes records with keys from from up to to excluding it.
+// Empty to deletes records up to the end of bucket. Returns number of
+// deleted records.
+// Bulk deletes apply onDelete actions of relations and emit Deleted events
+// only if Model is set in bucket options, otherwise records are deleted
+// without them
+// 		n, err := db.DeleteRange([]string{"bucket"}, "a", "m")
+func (db *DB) DeleteRange(path []string, from, to string) (int, error) {
+	path = db.scope(path)
+	l := logit(db.Log, "DELETE-RANGE", path, "", []string{from, to})
+	n, err := db.deleteScan(path, []byte(from), func(k []byte) bool {
+		return to != "" && bytes.Compare(k, []byte(to)) >= 0
+	}, nil)
+	return n, l.done(err)
+}
+
+// DeletePrefix deletes records with keys starting with prefix. Returns
+// number of deleted records
+// 		n, err := db.DeletePrefix([]string{"bucket"}, "user:")
+func (db *DB) DeletePrefix(path []string, prefix string) (int, error) {
+	path = db.scope(path)
+	l := logit(db.Log, "DELETE-PREFIX", path, prefix, nil)
+	n, err := db.deleteScan(path, []byte(prefix), func(k []byte) bool {
+		return !bytes.HasPrefix(k, []byte(prefix))
+	}, nil)
+	return n, l.done(err)
+}
+
+// DeleteWhere deletes records for which fn returns true. fn receives
+// decoded record. Returns number of deleted records
+// 		n, err := db.DeleteWhere([]string{"bucket"}, func(key string, value []byte) bool {
+// 			return bytes.Contains(value, []byte(`"Active":false`))
+// 		})
+func (db *DB) DeleteWhere(path []string, fn func(key string, value []byte) bool) (int, error) {
+	path = db.scope(path)
+	l := logit(db.Log, "DELETE-WHERE", path, "", nil)
+	n, err := db.deleteScan(path, nil, nil, fn)
+	return n, l.done(err)
+}
+
+// Truncate deletes all records of bucket keeping the bucket and its nested
+// buckets. Returns number of deleted records
+// 		n, err := db.Truncate([]string{"bucket"})
+func (db *DB) Truncate(path []string) (int, error) {
+	path = db.scope(path)
+	l := logit(db.Log, "TRUNCATE", path, "", nil)
+	n, err := db.deleteScan(path, nil, nil, nil)
+	return n, l.done(err)
+}
+
+// deleteScan deletes records starting from key start until stop returns true
+// for which match returns true. nil stop and match mean all records.
+// Records are deleted in batches of deleteBatch records so the database is
+// not locked for long. Batches deleted before error are kept
+func (db *DB) deleteScan(path []string, start []byte, stop func(k []byte) bool, match func(key string, value []byte) bool) (n int, err error) {
+	if err = db.check(path); err != nil {
+		return
+	}
+
+	next := start
+	for done := false; !done; {
+		var batch int
+		var deleted []mod
+		err = db.db.Update(func(tx *bolt.Tx) error {
+			b := getBucket(tx, path)
+			if b == nil {
+				return db.bucketNotFound(tx, path)
+			}
+
+			var keys []string
+			var olds [][]byte
+			c := b.Cursor()
+			k, v := c.First()
+			if next != nil {
+				k, v = c.Seek(next)
+			}
+			done = true
+			for ; k != nil; k, v = c.Next() {
+				if stop != nil && stop(k) {
+					break
+				}
+				if len(keys) == deleteBatch {
+					next = append([]byte{}, k...)
+					done = false
+					break
+				}
+				if v == nil {
+					continue
+				}
+				old, err := db.getRecord(b, path, string(k))
+				if err != nil {
+					return err
+				}
+				if match != nil && !match(string(k), old) {
+					continue
+				}
+				keys = append(keys, string(k))
+				olds = append(olds, old)
+			}
+
+			o := db.bucketOptions(path)
+			for i, k := range keys {
+				m := o.newModel()
+				if m == nil {
+					if err := db.deleteRecord(tx, b, path, k, olds[i]); err != nil {
+						return err
+					}
+					continue
+				}
+				if err := db.deleteModel(tx, path, k, reflect.TypeOf(m)); err != nil {
+					return err
+				}
+				if err := decode(olds[i], m); err == nil {
+					deleted = append(deleted, m)
+				}
+			}
+			batch = len(keys)
+			return nil
+		})
+		if err != nil {
+			return
+		}
+		n += batch
+		for _, m := range deleted {
+			addEvent("Deleted", m)
+		}
+	}
+	return
+}
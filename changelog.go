@@ -0,0 +1,198 @@
+package borm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// changeLogBucket is the root bucket holding change log entries
+const changeLogBucket = "_changelog"
+
+// Change operations
+const (
+	OpPut          = "put"
+	OpDelete       = "delete"
+	OpDeleteBucket = "delete-bucket"
+)
+
+// Change is a single committed mutation recorded in the change log
+type Change struct {
+	Seq   uint64
+	Op    string
+	Path  []string
+	Key   string
+	Value []byte
+	Time  time.Time
+}
+
+// changeFeed wakes up watchers after each committed change
+type changeFeed struct {
+	mu   sync.Mutex
+	wake chan struct{}
+}
+
+func newChangeFeed() *changeFeed {
+	return &changeFeed{wake: make(chan struct{})}
+}
+
+// wait returns channel closed on next notify
+func (f *changeFeed) wait() <-chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.wake
+}
+
+func (f *changeFeed) notify() {
+	f.mu.Lock()
+	close(f.wake)
+	f.wake = make(chan struct{})
+	f.mu.Unlock()
+}
+
+// logChange appends change into change log within transaction tx.
+// does nothing if change log is disabled
+func (db *DB) logChange(tx *bolt.Tx, op string, path []string, key string, val []byte) error {
+	if !db.ChangeLog {
+		return nil
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte(changeLogBucket))
+	if err != nil {
+		return err
+	}
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	if val, err = db.sealFields(tx, path, val); err != nil {
+		return err
+	}
+	c := Change{
+		Seq:   seq,
+		Op:    op,
+		Path:  path,
+		Key:   key,
+		Value: val,
+		Time:  db.now(),
+	}
+	enc, err := marshal(c)
+	if err != nil {
+		return err
+	}
+	if enc, err = db.pack(path, enc); err != nil {
+		return err
+	}
+	if db.feed != nil {
+		tx.OnCommit(db.feed.notify)
+	}
+	return b.Put(itob(seq), enc)
+}
+
+// Watch returns channel receiving changes starting from sequence fromSeq
+// and limited to buckets under pathPrefix. History is replayed first and
+// then live changes are delivered as they are committed.
+// Channel is closed when ctx is done or database is closed.
+// 		ch := db.Watch(ctx, 0, []string{"people"})
+// 		for c := range ch {
+// 			fmt.Println(c.Seq, c.Op, c.Key)
+// 		}
+func (db *DB) Watch(ctx context.Context, fromSeq uint64, pathPrefix []string) <-chan Change {
+	ch := make(chan Change)
+	go db.watch(ctx, fromSeq, append(append([]string{}, db.prefix...), pathPrefix...), ch)
+	return ch
+}
+
+func (db *DB) watch(ctx context.Context, next uint64, prefix []string, ch chan<- Change) {
+	defer close(ch)
+	if db.feed == nil {
+		return
+	}
+	for {
+		wake := db.feed.wait()
+		if !db.open {
+			return
+		}
+		changes, err := db.changesFrom(next)
+		if err != nil {
+			return
+		}
+		for _, c := range changes {
+			next = c.Seq + 1
+			if !hasPrefix(c.Path, prefix) {
+				continue
+			}
+			c.Path = c.Path[len(db.prefix):]
+			select {
+			case ch <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// changesFrom reads change log entries with sequence >= seq
+func (db *DB) changesFrom(seq uint64) (res []Change, err error) {
+	err = db.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(changeLogBucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Seek(itob(seq)); k != nil; k, v = c.Next() {
+			v, err := db.unwrap(v)
+			if err != nil {
+				return err
+			}
+			var ch Change
+			if err := unmarshal(v, &ch); err != nil {
+				return err
+			}
+			if ch.Value, err = db.openFields(ch.Value); err != nil {
+				return err
+			}
+			res = append(res, ch)
+		}
+		return nil
+	})
+	return
+}
+
+// TrimChangeLog deletes change log entries with sequence lower than beforeSeq
+func (db *DB) TrimChangeLog(beforeSeq uint64) error {
+	l := logit(db.Log, "TRIM-CHANGELOG", []string{changeLogBucket}, "", nil)
+	err := db.trimChangeLog(beforeSeq)
+	return l.done(err)
+}
+
+func (db *DB) trimChangeLog(beforeSeq uint64) error {
+	if err := db.check([]string{changeLogBucket}); err != nil {
+		return err
+	}
+	if len(db.prefix) > 0 {
+		return errors.New("Change log can't be trimmed by tenant")
+	}
+
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(changeLogBucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		limit := itob(beforeSeq)
+		for k, _ := c.First(); k != nil && string(k) < string(limit); k, _ = c.First() {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,242 @@
+package borm
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+var (
+	jsonMarshaler   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textMarshaler   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// encode encodes record for storage. Structs are encoded as JSON objects with
+// fields named by borm struct tags, other values are encoded as JSON
+func encode(i interface{}) ([]byte, error) {
+	v := reflect.ValueOf(i)
+	if !needsCodec(v.Type()) {
+		return marshal(i)
+	}
+	var buf bytes.Buffer
+	if err := encodeValue(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decode decodes record encoded with encode into i
+func decode(data []byte, i interface{}) error {
+	v := reflect.ValueOf(i)
+	if v.Kind() != reflect.Ptr || v.IsNil() || !needsCodec(v.Type()) {
+		return unmarshal(data, i)
+	}
+	// report empty data the same way as json does
+	if len(bytes.TrimSpace(data)) == 0 {
+		return unmarshal(data, i)
+	}
+	return decodeValue(data, v.Elem())
+}
+
+// needsCodec returns true if values of type t contain structs encoded
+// by struct tags
+func needsCodec(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return needsCodec(t.Elem())
+	case reflect.Struct:
+		return !customEncoding(t)
+	case reflect.Slice, reflect.Array:
+		return t.Elem().Kind() != reflect.Uint8 && needsCodec(t.Elem())
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String && needsCodec(t.Elem())
+	}
+	return false
+}
+
+// customEncoding returns true if type has its own JSON or text encoding
+func customEncoding(t reflect.Type) bool {
+	p := reflect.PtrTo(t)
+	return t.Implements(jsonMarshaler) || p.Implements(jsonMarshaler) ||
+		t.Implements(textMarshaler) || p.Implements(textMarshaler) ||
+		p.Implements(jsonUnmarshaler) || p.Implements(textUnmarshaler)
+}
+
+func encodeValue(buf *bytes.Buffer, v reflect.Value) error {
+	if !needsCodec(v.Type()) {
+		enc, err := marshal(v.Interface())
+		if err != nil {
+			return err
+		}
+		buf.Write(enc)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		return encodeValue(buf, v.Elem())
+	case reflect.Struct:
+		return encodeStruct(buf, v)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		buf.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeValue(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case reflect.Map:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			name, _ := marshal(k.String())
+			buf.Write(name)
+			buf.WriteByte(':')
+			if err := encodeValue(buf, v.MapIndex(k)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	}
+	return nil
+}
+
+func encodeStruct(buf *bytes.Buffer, v reflect.Value) error {
+	buf.WriteByte('{')
+	n := 0
+	for _, f := range getTypeInfo(v.Type()).fields {
+		fv, err := v.FieldByIndexErr(f.index)
+		if err != nil {
+			// field of nil embedded pointer
+			continue
+		}
+		if f.omitEmpty && isEmpty(fv) {
+			continue
+		}
+		if n > 0 {
+			buf.WriteByte(',')
+		}
+		n++
+		name, _ := marshal(f.name)
+		buf.Write(name)
+		buf.WriteByte(':')
+		if err := encodeValue(buf, fv); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func decodeValue(data []byte, v reflect.Value) error {
+	if !needsCodec(v.Type()) {
+		return unmarshal(data, v.Addr().Interface())
+	}
+	if string(bytes.TrimSpace(data)) == "null" {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeValue(data, v.Elem())
+	case reflect.Struct:
+		return decodeStruct(data, v)
+	case reflect.Slice, reflect.Array:
+		var items []json.RawMessage
+		if err := unmarshal(data, &items); err != nil {
+			return err
+		}
+		if v.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(v.Type(), len(items), len(items)))
+		}
+		for i := 0; i < len(items) && i < v.Len(); i++ {
+			if err := decodeValue(items[i], v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		var items map[string]json.RawMessage
+		if err := unmarshal(data, &items); err != nil {
+			return err
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for k, raw := range items {
+			item := reflect.New(v.Type().Elem()).Elem()
+			if err := decodeValue(raw, item); err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(k).Convert(v.Type().Key()), item)
+		}
+	}
+	return nil
+}
+
+func decodeStruct(data []byte, v reflect.Value) error {
+	var items map[string]json.RawMessage
+	if err := unmarshal(data, &items); err != nil {
+		return err
+	}
+	info := getTypeInfo(v.Type())
+	for name, raw := range items {
+		f := info.field(name)
+		if f == nil {
+			continue
+		}
+		if err := decodeValue(raw, fieldByIndexAlloc(v, f.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldByIndexAlloc returns nested field allocating nil embedded pointers
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// isEmpty reports whether v is empty in terms of omitempty
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
@@ -0,0 +1,202 @@
+package borm
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/boltdb/bolt"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression algorithms of bucket values
+const (
+	// CompressNone stores values as is
+	CompressNone = iota
+	// CompressFast compresses values with snappy
+	CompressFast
+	// CompressDense compresses values with zstd
+	CompressDense
+)
+
+// packedBucket is the meta bucket holding paths of buckets that have ever
+// had compressed or encrypted values
+const packedBucket = "packed"
+
+// DefaultCompressThreshold is minimal size of value compressed if bucket
+// options have no threshold set
+const DefaultCompressThreshold = 512
+
+// header bytes of stored values. JSON never starts with them so values
+// without header are stored as is
+const (
+	headerRaw byte = iota
+	headerFast
+	headerDense
+	headerSealed
+)
+
+var zstdCodec struct {
+	once sync.Once
+	enc  *zstd.Encoder
+	dec  *zstd.Decoder
+	err  error
+}
+
+func zstdInit() error {
+	zstdCodec.once.Do(func() {
+		zstdCodec.enc, zstdCodec.err = zstd.NewWriter(nil)
+		if zstdCodec.err != nil {
+			return
+		}
+		zstdCodec.dec, zstdCodec.err = zstd.NewReader(nil)
+	})
+	return zstdCodec.err
+}
+
+// pack compresses and encrypts value v stored in bucket path according
+// to its options
+func (db *DB) pack(path []string, v []byte) ([]byte, error) {
+	o := db.bucketOptions(path)
+	if v == nil || !db.packed(path) {
+		return v, nil
+	}
+
+	v, err := compress(v, o)
+	if err != nil || !o.Encrypt {
+		return v, err
+	}
+	return db.seal(v)
+}
+
+func compress(v []byte, o BucketOptions) ([]byte, error) {
+	threshold := o.CompressThreshold
+	if threshold <= 0 {
+		threshold = DefaultCompressThreshold
+	}
+	if o.Compression == CompressNone || len(v) < threshold {
+		if len(v) > 0 && v[0] <= headerSealed {
+			return append([]byte{headerRaw}, v...), nil
+		}
+		return v, nil
+	}
+
+	switch o.Compression {
+	case CompressFast:
+		return append([]byte{headerFast}, snappy.Encode(nil, v)...), nil
+	case CompressDense:
+		if err := zstdInit(); err != nil {
+			return nil, err
+		}
+		return zstdCodec.enc.EncodeAll(v, []byte{headerDense}), nil
+	}
+	return nil, fmt.Errorf("unknown compression %d", o.Compression)
+}
+
+// unwrap returns decrypted and uncompressed value v
+func (db *DB) unwrap(v []byte) ([]byte, error) {
+	if len(v) == 0 || v[0] > headerSealed {
+		return v, nil
+	}
+
+	switch v[0] {
+	case headerFast:
+		return snappy.Decode(nil, v[1:])
+	case headerDense:
+		if err := zstdInit(); err != nil {
+			return nil, err
+		}
+		return zstdCodec.dec.DecodeAll(v[1:], nil)
+	case headerSealed:
+		inner, err := db.unseal(v)
+		if err != nil {
+			return nil, err
+		}
+		return db.unwrap(inner)
+	}
+	return v[1:], nil
+}
+
+// unpack returns decrypted and uncompressed record value v of bucket path
+// with encrypted fields decrypted
+func (db *DB) unpack(path []string, v []byte) ([]byte, error) {
+	v, err := db.unpackValue(path, v)
+	if err != nil {
+		return nil, err
+	}
+	return db.openFields(v)
+}
+
+// unpackValue returns decrypted and uncompressed raw value v of bucket path.
+// Raw values may start with header bytes so they are unwrapped for packed
+// buckets only
+func (db *DB) unpackValue(path []string, v []byte) ([]byte, error) {
+	if !db.packed(path) {
+		return v, nil
+	}
+	return db.unwrap(v)
+}
+
+// packed returns true if values of bucket are compressed or encrypted by
+// its options or were packed before
+func (db *DB) packed(path []string) bool {
+	o := db.bucketOptions(path)
+	if o.Compression != CompressNone || o.Encrypt {
+		return true
+	}
+	if db.buckets == nil {
+		return false
+	}
+	db.buckets.mu.RLock()
+	defer db.buckets.mu.RUnlock()
+	return db.buckets.packed[pathKey(path)]
+}
+
+// markPacked stores that bucket has packed values so they are unpacked
+// after Open even without bucket options
+func (db *DB) markPacked(tx *bolt.Tx, path []string) error {
+	if !db.packed(path) {
+		return nil
+	}
+	key := []byte(pathKey(path))
+	if b := getBucket(tx, []string{metaBucket, packedBucket}); b != nil && b.Get(key) != nil {
+		return nil
+	}
+	b, err := createBucket(tx, []string{metaBucket, packedBucket})
+	if err != nil {
+		return fmt.Errorf("create bucket: %s", err)
+	}
+	if err := b.Put(key, []byte{1}); err != nil {
+		return err
+	}
+	tx.OnCommit(func() {
+		if db.buckets == nil {
+			return
+		}
+		db.buckets.mu.Lock()
+		db.buckets.packed[string(key)] = true
+		db.buckets.mu.Unlock()
+	})
+	return nil
+}
+
+// loadPacked loads paths of packed buckets into bucket registry
+func (db *DB) loadPacked() error {
+	return db.db.View(func(tx *bolt.Tx) error {
+		b := getBucket(tx, []string{metaBucket, packedBucket})
+		if b == nil {
+			return nil
+		}
+		db.buckets.mu.Lock()
+		defer db.buckets.mu.Unlock()
+		return b.ForEach(func(k, v []byte) error {
+			db.buckets.packed[string(k)] = true
+			return nil
+		})
+	})
+}
+
+// getRecord returns decrypted and uncompressed record id of bucket b
+func (db *DB) getRecord(b *bolt.Bucket, path []string, id string) ([]byte, error) {
+	return db.unpack(path, b.Get([]byte(id)))
+}
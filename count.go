@@ -0,0 +1,264 @@
+package borm
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/boltdb/bolt"
+)
+
+// countsBucket is the meta bucket holding number of records of buckets
+const countsBucket = "counts"
+
+// CountE returns number of records in bucket. Nested buckets are not counted
+// 		n, err := db.CountE([]string{"bucket"})
+func (db *DB) CountE(path []string) (int, error) {
+	path = db.scope(path)
+	l := logit(db.Log, "COUNT", path, "", nil)
+	res, err := db.count(path)
+	return res, l.done(err)
+}
+
+func (db *DB) count(path []string) (res int, err error) {
+	if err = db.check(path); err != nil {
+		return
+	}
+
+	err = db.db.View(func(tx *bolt.Tx) error {
+		b := getBucket(tx, path)
+		if b == nil {
+			return db.bucketNotFound(tx, path)
+		}
+		res = int(recordCount(tx, b, path))
+		return nil
+	})
+	return
+}
+
+// CountWhere returns number of records in bucket matching all conditions.
+// Indexes are used for equality conditions on indexed fields
+// 		n, err := db.CountWhere([]string{"orders"}, borm.Cond{Field: "Status", Op: "=", Value: "paid"})
+func (db *DB) CountWhere(path []string, conds ...Cond) (int, error) {
+	path = db.scope(path)
+	l := logit(db.Log, "COUNT-WHERE", path, "", conds)
+	res, err := db.countWhere(path, conds)
+	return res, l.done(err)
+}
+
+func (db *DB) countWhere(path []string, conds []Cond) (res int, err error) {
+	if err = db.check(path); err != nil {
+		return
+	}
+
+	err = db.db.View(func(tx *bolt.Tx) error {
+		b := getBucket(tx, path)
+		if b == nil {
+			return db.bucketNotFound(tx, path)
+		}
+		if len(conds) == 0 {
+			res = int(recordCount(tx, b, path))
+			return nil
+		}
+
+		ids, rest, err := indexedIDs(tx, path, conds)
+		if err != nil {
+			return err
+		}
+		if ids != nil && len(rest) == 0 {
+			res = len(ids)
+			return nil
+		}
+
+		fields := condFields(rest)
+		match := func(k, v []byte) error {
+			v, err := db.unpack(path, v)
+			if err != nil {
+				return err
+			}
+			rec, err := decodeFields(v, fields)
+			if err != nil {
+				return fmt.Errorf("could not decode %s: %s", k, err)
+			}
+			ok, err := matchConds(rec, rest)
+			if ok {
+				res++
+			}
+			return err
+		}
+
+		if ids == nil {
+			c := b.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				if v == nil {
+					continue
+				}
+				if err := match(k, v); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for _, id := range ids {
+			if v := b.Get([]byte(id)); v != nil {
+				if err := match([]byte(id), v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// indexedIDs returns ids of records matching equality conditions on indexed
+// fields and conditions that should be checked on records. nil ids means that
+// no index was used
+func indexedIDs(tx *bolt.Tx, path []string, conds []Cond) (ids []string, rest []Cond, err error) {
+	for _, c := range conds {
+		value, ok := indexCond(c)
+		if !ok {
+			rest = append(rest, c)
+			continue
+		}
+		found, ok, err := lookupIndex(tx, path, c.Field, value)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			rest = append(rest, c)
+			continue
+		}
+		if ids == nil {
+			ids = append([]string{}, found...)
+		} else {
+			ids = intersect(ids, found)
+		}
+	}
+	return
+}
+
+// indexCond returns indexed representation of value of equality condition.
+// ok is false if condition can't be checked by index
+func indexCond(c Cond) (string, bool) {
+	if c.Op != "=" && c.Op != "==" {
+		return "", false
+	}
+	switch c.Value.(type) {
+	case string, bool:
+	default:
+		switch reflect.ValueOf(c.Value).Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return "", false
+		}
+	}
+	return indexValue(c.Value)
+}
+
+func intersect(a, b []string) []string {
+	res := []string{}
+	for _, v := range a {
+		if contains(b, v) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// recordCount returns maintained number of records of bucket b
+func recordCount(tx *bolt.Tx, b *bolt.Bucket, path []string) int64 {
+	n, _ := bucketUsage(tx, b, path)
+	return n
+}
+
+// bucketUsage returns maintained number of records of bucket b and their
+// stored size. Records are counted if bucket has no counter yet
+func bucketUsage(tx *bolt.Tx, b *bolt.Bucket, path []string) (n, size int64) {
+	if cb := getBucket(tx, []string{metaBucket, countsBucket}); cb != nil {
+		if v := cb.Get([]byte(pathKey(path))); len(v) == 16 {
+			return int64(btoi(v[:8])), int64(btoi(v[8:]))
+		}
+	}
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v != nil {
+			n++
+			size += int64(len(v))
+		}
+	}
+	return
+}
+
+// adjustUsage adds delta records of size bytes to usage of bucket b checking
+// tenant quota. It should be called before record is added or deleted
+func (db *DB) adjustUsage(tx *bolt.Tx, b *bolt.Bucket, path []string, delta, size int64) error {
+	if delta > 0 || size > 0 {
+		if err := db.checkQuota(tx, path, delta, size); err != nil {
+			return err
+		}
+	}
+	n, s := bucketUsage(tx, b, path)
+	cb, err := createBucket(tx, []string{metaBucket, countsBucket})
+	if err != nil {
+		return fmt.Errorf("create bucket: %s", err)
+	}
+	return cb.Put([]byte(pathKey(path)), append(itob(uint64(n+delta)), itob(uint64(s+size))...))
+}
+
+// putCounted puts value into bucket b counting new records
+func (db *DB) putCounted(tx *bolt.Tx, b *bolt.Bucket, path []string, key string, val []byte) error {
+	var delta int64
+	old := b.Get([]byte(key))
+	if old == nil {
+		delta = 1
+	}
+	if err := db.adjustUsage(tx, b, path, delta, int64(len(val)-len(old))); err != nil {
+		return err
+	}
+	if err := db.markPacked(tx, path); err != nil {
+		return err
+	}
+	return b.Put([]byte(key), val)
+}
+
+// deleteCounted deletes record from bucket b if it exists
+func (db *DB) deleteCounted(tx *bolt.Tx, b *bolt.Bucket, path []string, key string) error {
+	old := b.Get([]byte(key))
+	if old == nil {
+		return nil
+	}
+	if err := db.adjustUsage(tx, b, path, -1, -int64(len(old))); err != nil {
+		return err
+	}
+	return b.Delete([]byte(key))
+}
+
+// dropMeta deletes metadata of bucket and its nested buckets
+func dropMeta(tx *bolt.Tx, path []string) error {
+	mb := tx.Bucket([]byte(metaBucket))
+	if mb == nil {
+		return nil
+	}
+	prefix := []byte(pathKey(path))
+	return mb.ForEach(func(name, v []byte) error {
+		b := mb.Bucket(name)
+		if v != nil || b == nil {
+			return nil
+		}
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			if len(k) == len(prefix) || k[len(prefix)] == 0 {
+				keys = append(keys, append([]byte{}, k...))
+			}
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
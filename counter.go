@@ -0,0 +1,136 @@
+package borm
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+)
+
+// Incr atomically increments counter stored by key and returns new value.
+// Counters are stored as decimal strings, missing counter starts from 0
+// 		n, err := db.Incr([]string{"counters"}, "visits", 1)
+func (db *DB) Incr(path []string, key string, delta int64) (int64, error) {
+	path = db.scope(path)
+	l := logit(db.Log, "INCR", path, key, nil)
+	v, err := db.incr(path, key, delta)
+	return v, l.done(err)
+}
+
+// Decr atomically decrements counter stored by key and returns new value
+// 		n, err := db.Decr([]string{"counters"}, "visits", 1)
+func (db *DB) Decr(path []string, key string, delta int64) (int64, error) {
+	path = db.scope(path)
+	l := logit(db.Log, "DECR", path, key, nil)
+	v, err := db.incr(path, key, -delta)
+	return v, l.done(err)
+}
+
+func (db *DB) incr(path []string, key string, delta int64) (res int64, err error) {
+	if err = db.check(path); err != nil {
+		return
+	}
+
+	err = db.db.Update(func(tx *bolt.Tx) error {
+		b, err := createBucket(tx, path)
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		v, err := db.unpackValue(path, b.Get([]byte(key)))
+		if err != nil {
+			return err
+		}
+		if v != nil {
+			if res, err = strconv.ParseInt(string(v), 10, 64); err != nil {
+				return fmt.Errorf("value of %s is not a number", key)
+			}
+		}
+		res += delta
+		val := []byte(strconv.FormatInt(res, 10))
+		enc, err := db.pack(path, val)
+		if err != nil {
+			return err
+		}
+		if err := db.putCounted(tx, b, path, key, enc); err != nil {
+			return err
+		}
+		return db.logChange(tx, OpPut, path, key, val)
+	})
+	return
+}
+
+// CompareAndSwap atomically replaces value stored by key with new one if
+// current value equals old. nil old means that key should not exist,
+// nil val deletes the key. Returns true if value was swapped
+// 		ok, err := db.CompareAndSwap([]string{"locks"}, "job", nil, []byte("worker1"))
+func (db *DB) CompareAndSwap(path []string, key string, old, val []byte) (bool, error) {
+	path = db.scope(path)
+	l := logit(db.Log, "CAS", path, key, val)
+	ok, err := db.compareAndSwap(path, key, old, val)
+	return ok, l.done(err)
+}
+
+func (db *DB) compareAndSwap(path []string, key string, old, val []byte) (ok bool, err error) {
+	if err = db.check(path); err != nil {
+		return
+	}
+
+	err = db.db.Update(func(tx *bolt.Tx) error {
+		b, err := createBucket(tx, path)
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		cur, err := db.unpackValue(path, b.Get([]byte(key)))
+		if err != nil {
+			return err
+		}
+		if (cur == nil) != (old == nil) || !bytes.Equal(cur, old) {
+			return nil
+		}
+		ok = true
+		if val == nil {
+			if cur == nil {
+				return nil
+			}
+			if err := db.deleteCounted(tx, b, path, key); err != nil {
+				return err
+			}
+			return db.logChange(tx, OpDelete, path, key, nil)
+		}
+		enc, err := db.pack(path, val)
+		if err != nil {
+			return err
+		}
+		if err := db.putCounted(tx, b, path, key, enc); err != nil {
+			return err
+		}
+		return db.logChange(tx, OpPut, path, key, val)
+	})
+	return
+}
+
+// NextSequence returns next autoincrement sequence of bucket
+// 		seq, err := db.NextSequence([]string{"people"})
+func (db *DB) NextSequence(path []string) (uint64, error) {
+	path = db.scope(path)
+	l := logit(db.Log, "NEXT-SEQUENCE", path, "", nil)
+	seq, err := db.nextSequence(path)
+	return seq, l.done(err)
+}
+
+func (db *DB) nextSequence(path []string) (seq uint64, err error) {
+	if err = db.check(path); err != nil {
+		return
+	}
+
+	err = db.db.Update(func(tx *bolt.Tx) error {
+		b, err := createBucket(tx, path)
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		seq, err = b.NextSequence()
+		return err
+	})
+	return
+}
@@ -0,0 +1,336 @@
+package borm
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+// KeyProvider provides AES keys used to encrypt values.
+// Keys must be 16, 24 or 32 bytes long
+type KeyProvider interface {
+	// CurrentKey returns id and key used to encrypt new values
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns key by id stored with encrypted value
+	Key(id string) ([]byte, error)
+}
+
+// KeyRing is KeyProvider keeping keys in memory. Last added key is current
+// 		keys := borm.NewKeyRing()
+// 		keys.Add("2024-01", key)
+// 		db.Keys = keys
+type KeyRing struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyRing returns empty key ring
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: make(map[string][]byte)}
+}
+
+// Add adds key with id and makes it current
+func (k *KeyRing) Add(id string, key []byte) {
+	k.mu.Lock()
+	k.keys[id] = key
+	k.current = id
+	k.mu.Unlock()
+}
+
+// CurrentKey returns current key
+func (k *KeyRing) CurrentKey() (string, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.current == "" {
+		return "", nil, errors.New("No keys in key ring")
+	}
+	return k.current, k.keys[k.current], nil
+}
+
+// Key returns key by id
+func (k *KeyRing) Key(id string) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("Key %s not found", id)
+	}
+	return key, nil
+}
+
+// size of data key encrypted with key provider key
+const wrappedKeySize = 12 + 32 + 16
+
+// encryptedField is stored in place of encrypted field value
+type encryptedField struct {
+	Enc []byte `json:"$enc"`
+}
+
+var encryptedPrefix = []byte(`{"$enc":`)
+
+// seal encrypts v with random data key. Data key is encrypted with current
+// key of key provider and stored with its id before encrypted value
+// 		headerSealed | len(id) | id | wrapped data key | nonce | ciphertext
+func (db *DB) seal(v []byte) ([]byte, error) {
+	if db.Keys == nil {
+		return nil, errors.New("Key provider not set")
+	}
+	id, key, err := db.Keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(id) > 255 {
+		return nil, fmt.Errorf("Key id %s is too long", id)
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	res := append([]byte{headerSealed, byte(len(id))}, id...)
+	if res, err = gcmSeal(res, key, dataKey); err != nil {
+		return nil, err
+	}
+	return gcmSeal(res, dataKey, v)
+}
+
+// unseal decrypts value encrypted with seal
+func (db *DB) unseal(v []byte) ([]byte, error) {
+	if len(v) < 2 || v[0] != headerSealed || len(v) < 2+int(v[1])+wrappedKeySize {
+		return nil, errors.New("Invalid encrypted value")
+	}
+	if db.Keys == nil {
+		return nil, errors.New("Key provider not set")
+	}
+	n := 2 + int(v[1])
+	key, err := db.Keys.Key(string(v[2:n]))
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := gcmOpen(key, v[n:n+wrappedKeySize])
+	if err != nil {
+		return nil, err
+	}
+	return gcmOpen(dataKey, v[n+wrappedKeySize:])
+}
+
+// gcmSeal appends random nonce and v encrypted with key to dst
+func gcmSeal(dst, key, v []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(append(dst, nonce...), nonce, v, nil), nil
+}
+
+// gcmOpen decrypts v encrypted with gcmSeal
+func gcmOpen(key, v []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) < gcm.NonceSize() {
+		return nil, errors.New("Invalid encrypted value")
+	}
+	return gcm.Open(nil, v[:gcm.NonceSize()], v[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadEncrypted returns encrypted fields of bucket
+func loadEncrypted(tx *bolt.Tx, path []string) ([]string, error) {
+	return loadMeta(tx, "encrypted", path)
+}
+
+// checkDerived returns error if encrypted bucket has indexes, full-text or
+// geo indexes or views which would store its values in plaintext
+func (db *DB) checkDerived(tx *bolt.Tx, path []string) error {
+	if !db.bucketOptions(path).Encrypt {
+		return nil
+	}
+	for _, name := range []string{"indexes", "fulltext", "geo"} {
+		fields, err := loadMeta(tx, name, path)
+		if err != nil {
+			return err
+		}
+		if len(fields) > 0 {
+			return encryptedDerived(path, name)
+		}
+	}
+	if len(db.sourceViews(path)) > 0 {
+		return encryptedDerived(path, "views")
+	}
+	return nil
+}
+
+func encryptedDerived(path []string, name string) error {
+	return fmt.Errorf("Bucket %s is encrypted and can't have %s", Path(path), name)
+}
+
+// sealFields encrypts fields of record v registered as encrypted for bucket
+func (db *DB) sealFields(tx *bolt.Tx, path []string, v []byte) ([]byte, error) {
+	fields, err := loadEncrypted(tx, path)
+	if err != nil || len(fields) == 0 || v == nil {
+		return v, err
+	}
+	var rec map[string]json.RawMessage
+	if err := unmarshal(v, &rec); err != nil {
+		// not a record
+		return v, nil
+	}
+
+	for _, f := range fields {
+		raw, ok := rec[f]
+		if !ok || bytes.HasPrefix(raw, encryptedPrefix) {
+			continue
+		}
+		enc, err := db.seal(raw)
+		if err != nil {
+			return nil, err
+		}
+		if rec[f], err = marshal(encryptedField{Enc: enc}); err != nil {
+			return nil, err
+		}
+	}
+	return marshal(rec)
+}
+
+// openFields decrypts encrypted fields of record v
+func (db *DB) openFields(v []byte) ([]byte, error) {
+	if !bytes.Contains(v, encryptedPrefix) {
+		return v, nil
+	}
+	var rec map[string]json.RawMessage
+	if err := unmarshal(v, &rec); err != nil {
+		return v, nil
+	}
+
+	for k, raw := range rec {
+		if !bytes.HasPrefix(raw, encryptedPrefix) {
+			continue
+		}
+		var f encryptedField
+		if err := unmarshal(raw, &f); err != nil {
+			return nil, err
+		}
+		dec, err := db.unseal(f.Enc)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt %s: %s", k, err)
+		}
+		rec[k] = dec
+	}
+	return marshal(rec)
+}
+
+// rekeyBatch is number of records re-encrypted in single transaction
+const rekeyBatch = 1000
+
+// Rekey re-encrypts records of bucket and its history with current key of
+// key provider and current bucket options. Records are processed in batches
+// so the database is not locked for long. Change log entries keep keys they
+// were encrypted with
+// 		keys.Add("2024-02", newKey)
+// 		db.Rekey([]string{"people"})
+func (db *DB) Rekey(path []string) error {
+	path = db.scope(path)
+	l := logit(db.Log, "REKEY", path, "", nil)
+	err := db.rekey(path, path, db.repackRecord)
+	if err == nil {
+		err = db.rekey(historyPath(path), path, db.repackRevision)
+	}
+	return l.done(err)
+}
+
+func (db *DB) rekey(bucket, path []string, repack func(*bolt.Tx, []string, []byte) ([]byte, error)) error {
+	if err := db.check(path); err != nil {
+		return err
+	}
+
+	var next []byte
+	for {
+		err := db.db.Update(func(tx *bolt.Tx) error {
+			b := getBucket(tx, bucket)
+			if b == nil {
+				return nil
+			}
+
+			var keys, vals [][]byte
+			c := b.Cursor()
+			k, v := c.First()
+			if next != nil {
+				k, v = c.Seek(next)
+			}
+			next = nil
+			for ; k != nil; k, v = c.Next() {
+				if len(keys) == rekeyBatch {
+					next = append([]byte{}, k...)
+					break
+				}
+				if v == nil {
+					continue
+				}
+				val, err := repack(tx, path, v)
+				if err != nil {
+					return fmt.Errorf("rekey %s: %s", k, err)
+				}
+				keys = append(keys, append([]byte{}, k...))
+				vals = append(vals, val)
+			}
+
+			for i, k := range keys {
+				var err error
+				if pathKey(bucket) == pathKey(path) {
+					err = db.putCounted(tx, b, path, string(k), vals[i])
+				} else {
+					err = b.Put(k, vals[i])
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil || next == nil {
+			return err
+		}
+	}
+}
+
+// repackRecord encrypts stored record v of bucket path again
+func (db *DB) repackRecord(tx *bolt.Tx, path []string, v []byte) ([]byte, error) {
+	v, err := db.unpack(path, v)
+	if err != nil {
+		return nil, err
+	}
+	if v, err = db.sealFields(tx, path, v); err != nil {
+		return nil, err
+	}
+	return db.pack(path, v)
+}
+
+// repackRevision encrypts stored revision v of record of bucket path again
+func (db *DB) repackRevision(tx *bolt.Tx, path []string, v []byte) ([]byte, error) {
+	r, err := db.unpackRevision(v)
+	if err != nil {
+		return nil, err
+	}
+	return db.packRevision(tx, path, r)
+}
@@ -0,0 +1,118 @@
+package borm
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+// FieldChange describes change of a single model field
+type FieldChange struct {
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+// Diff is a list of changed model fields
+type Diff []FieldChange
+
+// Changed returns true if field f was changed
+func (d Diff) Changed(f string) bool {
+	for _, v := range d {
+		if v.Field == f {
+			return true
+		}
+	}
+	return false
+}
+
+// Changes returns fields of model that differ from the stored record.
+// All fields are returned for records that are not stored yet
+// 		p.Name = "New Name"
+// 		diff, err := db.Changes([]string{"people"}, &p)
+func (db *DB) Changes(path []string, m mod) (Diff, error) {
+	path = db.scope(path)
+	l := logit(db.Log, "CHANGES", path, m.GetID(), m)
+	d, err := db.changes(path, m)
+	return d, l.done(err)
+}
+
+func (db *DB) changes(path []string, m mod) (d Diff, err error) {
+	if err = db.check(path); err != nil {
+		return
+	}
+
+	err = db.db.View(func(tx *bolt.Tx) error {
+		var old []byte
+		if b := getBucket(tx, path); b != nil && m.GetID() != "" {
+			if old, err = db.getRecord(b, path, m.GetID()); err != nil {
+				return err
+			}
+		}
+		d, err = diffRecord(old, m)
+		return err
+	})
+	return
+}
+
+// diffRecord compares stored record encoding with model.
+// fields managed by borm are not compared
+func diffRecord(old []byte, m mod) (Diff, error) {
+	a, err := unmarshalMap(old)
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := encode(m)
+	if err != nil {
+		return nil, err
+	}
+	b, err := unmarshalMap(enc)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffMaps(a, b, managedFields(m)), nil
+}
+
+// diffMaps compares decoded records skipping ignored fields
+func diffMaps(a, b map[string]interface{}, ignore []string) Diff {
+	skip := make(map[string]bool)
+	for _, f := range ignore {
+		skip[f] = true
+	}
+
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		if !skip[k] {
+			keys = append(keys, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok && !skip[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var d Diff
+	for _, k := range keys {
+		if !reflect.DeepEqual(a[k], b[k]) {
+			d = append(d, FieldChange{Field: k, Old: a[k], New: b[k]})
+		}
+	}
+	return d
+}
+
+// managedFields returns names of fields updated by borm on save
+func managedFields(m mod) []string {
+	res := []string{"ID"}
+	if _, ok := m.(modCreate); ok {
+		res = append(res, "Created")
+	}
+	if _, ok := m.(modUpdate); ok {
+		res = append(res, "Updated")
+	}
+	return res
+}
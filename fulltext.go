@@ -0,0 +1,359 @@
+package borm
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/boltdb/bolt"
+)
+
+// fulltextSuffix is appended to bucket name to get full-text index bucket name
+const fulltextSuffix = "_fulltext"
+
+// full-text index sub-buckets
+const (
+	ftTerms = "terms"
+	ftDocs  = "docs"
+	ftStats = "stats"
+)
+
+// BM25 ranking parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// SearchOptions are options of full-text search
+type SearchOptions struct {
+	// Limit is maximum number of results. Default is 1000
+	Limit int
+	// Prefix matches terms starting with query words
+	Prefix bool
+	// Fuzzy is maximum edit distance of terms matching query words
+	Fuzzy int
+}
+
+// Search fills models slice with records matching query in fields tagged
+// with borm:"fulltext". Records are ordered by relevance
+// 		type Person struct {
+// 			borm.Model
+// 			Name string `borm:"fulltext"`
+// 		}
+// 		res := []Person{}
+// 		db.Search([]string{"people"}, "john doe", &res, borm.SearchOptions{Prefix: true})
+func (db *DB) Search(path []string, query string, dest interface{}, opts ...SearchOptions) error {
+	path = db.scope(path)
+	l := logit(db.Log, "SEARCH", path, query, nil)
+	err := db.search(path, query, dest, opts...)
+	return l.done(err)
+}
+
+func (db *DB) search(path []string, query string, dest interface{}, opts ...SearchOptions) error {
+	if err := db.check(path); err != nil {
+		return err
+	}
+	o := SearchOptions{Limit: 1000}
+	if len(opts) > 0 {
+		o = opts[0]
+		if o.Limit <= 0 {
+			o.Limit = 1000
+		}
+	}
+
+	var keys [][]byte
+	err := db.db.View(func(tx *bolt.Tx) error {
+		if getBucket(tx, path) == nil {
+			return db.bucketNotFound(tx, path)
+		}
+		ids, err := rankSearch(tx, path, tokenize(query), o)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			keys = append(keys, []byte(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return db.listKeys(path, keys, dest)
+}
+
+// rankSearch returns ids of records matching words ordered by BM25 score
+func rankSearch(tx *bolt.Tx, path []string, words []string, o SearchOptions) ([]string, error) {
+	terms := getBucket(tx, ftPath(path, ftTerms))
+	docs := getBucket(tx, ftPath(path, ftDocs))
+	stats := getBucket(tx, ftPath(path, ftStats))
+	if terms == nil || docs == nil || stats == nil {
+		return nil, nil
+	}
+	n := float64(statValue(stats, ftDocs))
+	if n == 0 {
+		return nil, nil
+	}
+	avg := float64(statValue(stats, ftTerms)) / n
+
+	scores := make(map[string]float64)
+	for _, term := range matchTerms(terms, words, o) {
+		prefix := []byte(term + "\x00")
+		var postings [][2][]byte
+		c := terms.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			postings = append(postings, [2][]byte{k[len(prefix):], v})
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for _, p := range postings {
+			tf := float64(btoi(p[1]))
+			dl := float64(btoi(docs.Get(p[0])))
+			scores[string(p[0])] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*dl/avg))
+		}
+	}
+
+	ids := make([]string, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > o.Limit {
+		ids = ids[:o.Limit]
+	}
+	return ids, nil
+}
+
+// matchTerms returns indexed terms matching query words
+func matchTerms(terms *bolt.Bucket, words []string, o SearchOptions) []string {
+	var res []string
+	c := terms.Cursor()
+	for _, w := range words {
+		if o.Fuzzy > 0 {
+			last := ""
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				t := string(k[:bytes.IndexByte(k, 0)])
+				if t == last {
+					continue
+				}
+				last = t
+				if o.Prefix && strings.HasPrefix(t, w) || levenshtein(w, t) <= o.Fuzzy {
+					res = appendUnique(res, t)
+				}
+			}
+			continue
+		}
+		if !o.Prefix {
+			res = appendUnique(res, w)
+			continue
+		}
+		for k, _ := c.Seek([]byte(w)); k != nil && bytes.HasPrefix(k, []byte(w)); k, _ = c.Next() {
+			res = appendUnique(res, string(k[:bytes.IndexByte(k, 0)]))
+		}
+	}
+	return res
+}
+
+// ensureFulltext registers full-text fields of bucket and rebuilds
+// full-text index if fields are new
+func (db *DB) ensureFulltext(tx *bolt.Tx, path []string, fields []string) error {
+	added, err := extendMeta(tx, "fulltext", path, fields)
+	if err != nil || len(added) == 0 {
+		return err
+	}
+	all, err := loadMeta(tx, "fulltext", path)
+	if err != nil {
+		return err
+	}
+
+	ft, err := createBucket(tx, fulltextPath(path))
+	if err != nil {
+		return fmt.Errorf("create fulltext bucket: %s", err)
+	}
+	for _, name := range []string{ftTerms, ftDocs, ftStats} {
+		if ft.Bucket([]byte(name)) == nil {
+			continue
+		}
+		if err := ft.DeleteBucket([]byte(name)); err != nil {
+			return err
+		}
+	}
+
+	b := getBucket(tx, path)
+	if b == nil {
+		return nil
+	}
+	return b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		v, err := db.unpack(path, v)
+		if err != nil {
+			return err
+		}
+		return fulltextRecord(tx, path, all, string(k), nil, v)
+	})
+}
+
+// updateFulltext updates full-text index of bucket for record id changed
+// from old to enc. nil old means new record, nil enc means deleted record
+func updateFulltext(tx *bolt.Tx, path []string, id string, old, enc []byte) error {
+	fields, err := loadMeta(tx, "fulltext", path)
+	if err != nil || len(fields) == 0 {
+		return err
+	}
+	return fulltextRecord(tx, path, fields, id, old, enc)
+}
+
+func fulltextRecord(tx *bolt.Tx, path []string, fields []string, id string, old, enc []byte) error {
+	terms, err := createBucket(tx, ftPath(path, ftTerms))
+	if err != nil {
+		return fmt.Errorf("create fulltext bucket: %s", err)
+	}
+	docs, err := createBucket(tx, ftPath(path, ftDocs))
+	if err != nil {
+		return fmt.Errorf("create fulltext bucket: %s", err)
+	}
+	stats, err := createBucket(tx, ftPath(path, ftStats))
+	if err != nil {
+		return fmt.Errorf("create fulltext bucket: %s", err)
+	}
+
+	if docs.Get([]byte(id)) != nil {
+		freq, n, err := termFreq(old, fields)
+		if err != nil {
+			return err
+		}
+		for t := range freq {
+			if err := terms.Delete([]byte(t + "\x00" + id)); err != nil {
+				return err
+			}
+		}
+		if err := docs.Delete([]byte(id)); err != nil {
+			return err
+		}
+		if err := addStats(stats, -1, -n); err != nil {
+			return err
+		}
+	}
+	if enc == nil {
+		return nil
+	}
+
+	freq, n, err := termFreq(enc, fields)
+	if err != nil {
+		return err
+	}
+	for t, f := range freq {
+		if err := terms.Put([]byte(t+"\x00"+id), itob(uint64(f))); err != nil {
+			return err
+		}
+	}
+	if err := docs.Put([]byte(id), itob(uint64(n))); err != nil {
+		return err
+	}
+	return addStats(stats, 1, n)
+}
+
+// termFreq returns frequencies of terms in fields of record enc and total
+// number of terms
+func termFreq(enc []byte, fields []string) (map[string]int, int, error) {
+	rec, err := unmarshalMap(enc)
+	if err != nil {
+		return nil, 0, err
+	}
+	res := make(map[string]int)
+	n := 0
+	for _, f := range fields {
+		for _, t := range tokenize(fieldText(rec[f])) {
+			res[t]++
+			n++
+		}
+	}
+	return res, n, nil
+}
+
+// fieldText returns text of decoded field value
+func fieldText(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []interface{}:
+		s := make([]string, 0, len(t))
+		for _, i := range t {
+			s = append(s, fieldText(i))
+		}
+		return strings.Join(s, " ")
+	}
+	return ""
+}
+
+// tokenize splits text into lower case words
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func statValue(b *bolt.Bucket, key string) int64 {
+	v := b.Get([]byte(key))
+	if v == nil {
+		return 0
+	}
+	return int64(btoi(v))
+}
+
+func addStats(b *bolt.Bucket, docs, terms int) error {
+	if err := b.Put([]byte(ftDocs), itob(uint64(statValue(b, ftDocs)+int64(docs)))); err != nil {
+		return err
+	}
+	return b.Put([]byte(ftTerms), itob(uint64(statValue(b, ftTerms)+int64(terms))))
+}
+
+// fulltextPath returns path of full-text index bucket of bucket path
+func fulltextPath(path []string) []string {
+	res := make([]string, len(path), len(path)+1)
+	copy(res, path)
+	res[len(res)-1] += fulltextSuffix
+	return res
+}
+
+// ftPath returns path of full-text index sub-bucket name of bucket path
+func ftPath(path []string, name string) []string {
+	return append(fulltextPath(path), name)
+}
+
+// levenshtein returns edit distance between a and b
+func levenshtein(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(t)]
+}
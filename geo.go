@@ -0,0 +1,361 @@
+package borm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// geoSuffix is appended to bucket name to get geo index bucket name
+const geoSuffix = "_geo"
+
+// earthRadius is mean Earth radius in meters
+const earthRadius = 6371000
+
+// geohash parameters
+const (
+	geoPrecision = 12
+	geoMaxCells  = 32
+	geoAlphabet  = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+// Point is a geographic location. Fields of Point type tagged with
+// borm:"geo" are indexed for Near and WithinBox queries
+// 		type Venue struct {
+// 			borm.Model
+// 			Location borm.Point `borm:"geo"`
+// 		}
+type Point struct {
+	Lat float64
+	Lng float64
+}
+
+// Near fills models slice with records located within radius meters from
+// lat, lng ordered by distance
+// 		res := []Venue{}
+// 		db.Near([]string{"venues"}, 52.52, 13.40, 1000, &res, borm.Params{Limit: 10})
+func (db *DB) Near(path []string, lat, lng, radius float64, dest interface{}, params ...Params) error {
+	path = db.scope(path)
+	l := logit(db.Log, "NEAR", path, "", []float64{lat, lng, radius})
+	err := db.geoQuery(path, dest, parseParams(params), circleBox(lat, lng, radius), func(p Point) (float64, bool) {
+		d := distance(lat, lng, p.Lat, p.Lng)
+		return d, d <= radius
+	})
+	return l.done(err)
+}
+
+// WithinBox fills models slice with records located within bounding box.
+// Box crosses the antimeridian if minLng is greater than maxLng
+// 		res := []Venue{}
+// 		db.WithinBox([]string{"venues"}, 52.3, 13.0, 52.7, 13.8, &res)
+func (db *DB) WithinBox(path []string, minLat, minLng, maxLat, maxLng float64, dest interface{}, params ...Params) error {
+	path = db.scope(path)
+	l := logit(db.Log, "WITHINBOX", path, "", []float64{minLat, minLng, maxLat, maxLng})
+	box := [4]float64{minLat, minLng, maxLat, maxLng}
+	err := db.geoQuery(path, dest, parseParams(params), box, func(p Point) (float64, bool) {
+		inLng := p.Lng >= minLng && p.Lng <= maxLng
+		if minLng > maxLng {
+			inLng = p.Lng >= minLng || p.Lng <= maxLng
+		}
+		return 0, p.Lat >= minLat && p.Lat <= maxLat && inLng
+	})
+	return l.done(err)
+}
+
+// geoQuery fills dest with records located in geohash cells covering box
+// and accepted by match. Records are ordered by distance returned by match
+func (db *DB) geoQuery(path []string, dest interface{}, opts Params, box [4]float64, match func(Point) (float64, bool)) error {
+	if err := db.check(path); err != nil {
+		return err
+	}
+
+	var keys [][]byte
+	err := db.db.View(func(tx *bolt.Tx) error {
+		if getBucket(tx, path) == nil {
+			return db.bucketNotFound(tx, path)
+		}
+		fields, err := loadMeta(tx, "geo", path)
+		if err != nil {
+			return err
+		}
+
+		dist := make(map[string]float64)
+		var ids []string
+		for _, f := range fields {
+			b := getBucket(tx, geoPath(path, f))
+			if b == nil {
+				continue
+			}
+			c := b.Cursor()
+			for _, cell := range coverBox(box) {
+				prefix := []byte(cell)
+				for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+					d, ok := match(Point{Lat: math.Float64frombits(btoi(v[:8])), Lng: math.Float64frombits(btoi(v[8:]))})
+					if !ok {
+						continue
+					}
+					id := string(k[geoPrecision+1:])
+					if cur, seen := dist[id]; !seen {
+						ids = append(ids, id)
+					} else if cur < d {
+						continue
+					}
+					dist[id] = d
+				}
+			}
+		}
+
+		sort.SliceStable(ids, func(i, j int) bool { return dist[ids[i]] < dist[ids[j]] })
+		for i, id := range ids {
+			if i < opts.Offset || len(keys) >= opts.Limit {
+				continue
+			}
+			keys = append(keys, []byte(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return db.listKeys(path, keys, dest)
+}
+
+// ensureGeo registers geo indexes of bucket and builds ones that are new
+func (db *DB) ensureGeo(tx *bolt.Tx, path []string, fields []string) error {
+	added, err := extendMeta(tx, "geo", path, fields)
+	if err != nil || len(added) == 0 {
+		return err
+	}
+
+	b := getBucket(tx, path)
+	if b == nil {
+		return nil
+	}
+	return b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		v, err := db.unpack(path, v)
+		if err != nil {
+			return err
+		}
+		return geoRecord(tx, path, added, string(k), nil, v)
+	})
+}
+
+// updateGeo updates geo indexes of bucket for record id changed from old
+// to enc. nil old means new record, nil enc means deleted record
+func updateGeo(tx *bolt.Tx, path []string, id string, old, enc []byte) error {
+	fields, err := loadMeta(tx, "geo", path)
+	if err != nil || len(fields) == 0 {
+		return err
+	}
+	return geoRecord(tx, path, fields, id, old, enc)
+}
+
+func geoRecord(tx *bolt.Tx, path []string, fields []string, id string, old, enc []byte) error {
+	a, err := unmarshalMap(old)
+	if err != nil {
+		return err
+	}
+	n, err := unmarshalMap(enc)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fields {
+		op, oldOk := geoPoint(a[f])
+		np, newOk := geoPoint(n[f])
+		if oldOk == newOk && op == np {
+			continue
+		}
+		b, err := createBucket(tx, geoPath(path, f))
+		if err != nil {
+			return fmt.Errorf("create geo bucket: %s", err)
+		}
+		if oldOk {
+			if err := b.Delete(geoKey(op, id)); err != nil {
+				return err
+			}
+		}
+		if newOk {
+			v := append(itob(math.Float64bits(np.Lat)), itob(math.Float64bits(np.Lng))...)
+			if err := b.Put(geoKey(np, id), v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// geoPoint returns point of decoded field value. Objects with Lat and Lng
+// (or Lon) keys and [lat, lng] arrays are accepted
+func geoPoint(v interface{}) (p Point, ok bool) {
+	var lat, lng interface{}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			switch strings.ToLower(k) {
+			case "lat":
+				lat = val
+			case "lng", "lon":
+				lng = val
+			}
+		}
+	case []interface{}:
+		if len(t) == 2 {
+			lat, lng = t[0], t[1]
+		}
+	}
+	a, aok := lat.(json.Number)
+	b, bok := lng.(json.Number)
+	if !aok || !bok {
+		return p, false
+	}
+	var err error
+	if p.Lat, err = a.Float64(); err != nil {
+		return p, false
+	}
+	if p.Lng, err = b.Float64(); err != nil {
+		return p, false
+	}
+	return p, p.Lat >= -90 && p.Lat <= 90 && p.Lng >= -180 && p.Lng <= 180
+}
+
+// geoPath returns path of geo index bucket of field
+func geoPath(path []string, field string) []string {
+	res := make([]string, len(path), len(path)+1)
+	copy(res, path)
+	res[len(res)-1] += geoSuffix
+	return append(res, field)
+}
+
+func geoKey(p Point, id string) []byte {
+	return append([]byte(geohash(p.Lat, p.Lng, geoPrecision)+"\x00"), id...)
+}
+
+// geohash returns geohash of location with precision characters
+func geohash(lat, lng float64, precision int) string {
+	minLat, maxLat, minLng, maxLng := -90.0, 90.0, -180.0, 180.0
+	res := make([]byte, 0, precision)
+	ch, bit, even := 0, 0, true
+	for len(res) < precision {
+		if even {
+			mid := (minLng + maxLng) / 2
+			if lng >= mid {
+				ch |= 1 << uint(4-bit)
+				minLng = mid
+			} else {
+				maxLng = mid
+			}
+		} else {
+			mid := (minLat + maxLat) / 2
+			if lat >= mid {
+				ch |= 1 << uint(4-bit)
+				minLat = mid
+			} else {
+				maxLat = mid
+			}
+		}
+		even = !even
+		if bit < 4 {
+			bit++
+			continue
+		}
+		res = append(res, geoAlphabet[ch])
+		ch, bit = 0, 0
+	}
+	return string(res)
+}
+
+// cellSize returns height and width in degrees of geohash cell
+func cellSize(precision int) (float64, float64) {
+	bits := 5 * precision
+	return 180 / math.Pow(2, float64(bits/2)), 360 / math.Pow(2, float64(bits-bits/2))
+}
+
+// coverBox returns geohash cells covering bounding box
+// {minLat, minLng, maxLat, maxLng}. Boxes crossing the antimeridian are
+// covered as two boxes split at it
+func coverBox(box [4]float64) []string {
+	if box[1] <= box[3] {
+		return coverPart(box)
+	}
+	res := coverPart([4]float64{box[0], box[1], box[2], 180})
+	for _, cell := range coverPart([4]float64{box[0], -180, box[2], box[3]}) {
+		res = appendUnique(res, cell)
+	}
+	return res
+}
+
+// coverPart returns geohash cells covering bounding box not crossing the
+// antimeridian. The most precise cells are used while their number does not
+// exceed geoMaxCells
+func coverPart(box [4]float64) []string {
+	for p := geoPrecision; p > 1; p-- {
+		h, w := cellSize(p)
+		rows := math.Floor((box[2]+90)/h) - math.Floor((box[0]+90)/h) + 1
+		cols := math.Floor((box[3]+180)/w) - math.Floor((box[1]+180)/w) + 1
+		if rows*cols <= geoMaxCells {
+			return boxCells(box, p)
+		}
+	}
+	return boxCells(box, 1)
+}
+
+func boxCells(box [4]float64, precision int) []string {
+	h, w := cellSize(precision)
+	var res []string
+	for lat := box[0]; ; lat += h {
+		lat = math.Min(lat, box[2])
+		for lng := box[1]; ; lng += w {
+			lng = math.Min(lng, box[3])
+			res = appendUnique(res, geohash(lat, lng, precision))
+			if lng >= box[3] {
+				break
+			}
+		}
+		if lat >= box[2] {
+			break
+		}
+	}
+	return res
+}
+
+// circleBox returns bounding box of circle with radius meters. Box of circle
+// crossing the antimeridian has minLng greater than maxLng
+func circleBox(lat, lng, radius float64) [4]float64 {
+	dLat := radius / earthRadius * 180 / math.Pi
+	dLng := 180.0
+	if c := math.Cos(lat * math.Pi / 180); c > 1e-9 {
+		dLng = dLat / c
+	}
+	box := [4]float64{math.Max(lat-dLat, -90), -180, math.Min(lat+dLat, 90), 180}
+	// circles reaching a pole cover all longitudes
+	if dLng >= 180 || lat-dLat <= -90 || lat+dLat >= 90 {
+		return box
+	}
+	box[1], box[3] = lng-dLng, lng+dLng
+	if box[1] < -180 {
+		box[1] += 360
+	}
+	if box[3] > 180 {
+		box[3] -= 360
+	}
+	return box
+}
+
+// distance returns great circle distance in meters between two locations
+func distance(lat1, lng1, lat2, lng2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLng := (lng2 - lng1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(math.Min(a, 1)))
+}
@@ -0,0 +1,225 @@
+package borm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// historySuffix is appended to bucket name to get history bucket name
+const historySuffix = "_history"
+
+type actorKey struct{}
+
+// Revision is a previous version of a record stored in history bucket
+type Revision struct {
+	Rev   uint64
+	Op    string
+	Actor string
+	Time  time.Time
+	Data  []byte
+}
+
+// WithActor returns context carrying actor stored with history revisions
+// 		ctx := borm.WithActor(context.Background(), "admin")
+// 		db.WithContext(ctx).Save([]string{"people"}, &p)
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns actor stored in context
+func ActorFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	a, _ := ctx.Value(actorKey{}).(string)
+	return a
+}
+
+// WithContext returns database handle using ctx for its operations
+func (db *DB) WithContext(ctx context.Context) *DB {
+	d := *db
+	d.ctx = ctx
+	return &d
+}
+
+// History returns all stored revisions of record, oldest first
+// 		revs, err := db.History([]string{"people"}, p.ID)
+func (db *DB) History(path []string, id string) ([]Revision, error) {
+	path = db.scope(path)
+	l := logit(db.Log, "HISTORY", path, id, nil)
+	res, err := db.history(path, id)
+	return res, l.done(err)
+}
+
+func (db *DB) history(path []string, id string) (res []Revision, err error) {
+	if err = db.check(path); err != nil {
+		return
+	}
+
+	err = db.db.View(func(tx *bolt.Tx) error {
+		b := getBucket(tx, historyPath(path))
+		if b == nil {
+			return nil
+		}
+		prefix := historyPrefix(id)
+		c := b.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			r, err := db.unpackRevision(v)
+			if err != nil {
+				return err
+			}
+			res = append(res, r)
+		}
+		return nil
+	})
+	return
+}
+
+// Revision fills model with record data stored in revision rev
+// 		m := Model{}
+// 		db.Revision([]string{"people"}, p.ID, 1, &m)
+func (db *DB) Revision(path []string, id string, rev uint64, i interface{}) error {
+	path = db.scope(path)
+	l := logit(db.Log, "REVISION", path, id, nil)
+	err := db.revision(path, id, rev, i)
+	return l.done(err)
+}
+
+func (db *DB) revision(path []string, id string, rev uint64, i interface{}) error {
+	if err := db.check(path); err != nil {
+		return err
+	}
+
+	return db.db.View(func(tx *bolt.Tx) error {
+		r, err := db.getRevision(tx, path, id, rev)
+		if err != nil {
+			return err
+		}
+		return decode(r.Data, i)
+	})
+}
+
+// Revert restores record to the state stored in revision rev.
+// Current state of record is stored in history as a new revision
+// 		db.Revert([]string{"people"}, p.ID, 1)
+func (db *DB) Revert(path []string, id string, rev uint64) error {
+	path = db.scope(path)
+	l := logit(db.Log, "REVERT", path, id, nil)
+	err := db.revert(path, id, rev)
+	return l.done(err)
+}
+
+func (db *DB) revert(path []string, id string, rev uint64) error {
+	if err := db.check(path); err != nil {
+		return err
+	}
+
+	return db.db.Update(func(tx *bolt.Tx) error {
+		r, err := db.getRevision(tx, path, id, rev)
+		if err != nil {
+			return err
+		}
+		b, err := createBucket(tx, path)
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		old, err := db.getRecord(b, path, id)
+		if err != nil {
+			return err
+		}
+		return db.putRecord(tx, b, path, "revert", id, old, r.Data)
+	})
+}
+
+// archive stores previous version of record into history bucket if
+// history is enabled for bucket
+func (db *DB) archive(tx *bolt.Tx, path []string, id string, op string, old []byte) error {
+	if old == nil || !db.bucketOptions(path).History {
+		return nil
+	}
+	b, err := createBucket(tx, historyPath(path))
+	if err != nil {
+		return fmt.Errorf("create history bucket: %s", err)
+	}
+
+	r := Revision{
+		Rev:   lastRevision(b, id) + 1,
+		Op:    op,
+		Actor: ActorFrom(db.ctx),
+		Time:  db.now(),
+		Data:  old,
+	}
+	enc, err := db.packRevision(tx, path, r)
+	if err != nil {
+		return err
+	}
+	return b.Put(historyKey(id, r.Rev), enc)
+}
+
+// packRevision encodes revision of record of bucket path for storage.
+// Revisions are encrypted and compressed the same way as records
+func (db *DB) packRevision(tx *bolt.Tx, path []string, r Revision) ([]byte, error) {
+	var err error
+	if r.Data, err = db.sealFields(tx, path, r.Data); err != nil {
+		return nil, err
+	}
+	enc, err := marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return db.pack(path, enc)
+}
+
+// unpackRevision decodes stored revision v
+func (db *DB) unpackRevision(v []byte) (r Revision, err error) {
+	if v, err = db.unwrap(v); err != nil {
+		return
+	}
+	if err = unmarshal(v, &r); err != nil {
+		return
+	}
+	r.Data, err = db.openFields(r.Data)
+	return
+}
+
+func (db *DB) getRevision(tx *bolt.Tx, path []string, id string, rev uint64) (r Revision, err error) {
+	b := getBucket(tx, historyPath(path))
+	if b == nil {
+		return r, errors.New("History not found")
+	}
+	v := b.Get(historyKey(id, rev))
+	if v == nil {
+		return r, fmt.Errorf("Revision %d not found", rev)
+	}
+	return db.unpackRevision(v)
+}
+
+func lastRevision(b *bolt.Bucket, id string) (rev uint64) {
+	prefix := historyPrefix(id)
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		rev = btoi(k[len(prefix):])
+	}
+	return
+}
+
+// historyPath returns path of sibling history bucket
+func historyPath(path []string) []string {
+	res := make([]string, len(path))
+	copy(res, path)
+	res[len(res)-1] += historySuffix
+	return res
+}
+
+func historyPrefix(id string) []byte {
+	return append([]byte(id), 0)
+}
+
+func historyKey(id string, rev uint64) []byte {
+	return append(historyPrefix(id), itob(rev)...)
+}
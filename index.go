@@ -0,0 +1,248 @@
+package borm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// metaBucket is the root bucket holding borm metadata
+const metaBucket = "_meta"
+
+// indexSuffix is appended to bucket name to get indexes bucket name
+const indexSuffix = "_index"
+
+// loadMeta returns list stored in meta bucket name for bucket path
+func loadMeta(tx *bolt.Tx, name string, path []string) (res []string, err error) {
+	b := getBucket(tx, []string{metaBucket, name})
+	if b == nil {
+		return
+	}
+	if v := b.Get([]byte(pathKey(path))); v != nil {
+		err = unmarshal(v, &res)
+	}
+	return
+}
+
+// extendMeta adds items into list stored in meta bucket name for bucket path
+// and returns items that were added
+func extendMeta(tx *bolt.Tx, name string, path []string, items []string) ([]string, error) {
+	cur, err := loadMeta(tx, name, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var added []string
+	for _, f := range items {
+		if !contains(cur, f) {
+			cur = append(cur, f)
+			added = append(added, f)
+		}
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	mb, err := createBucket(tx, []string{metaBucket, name})
+	if err != nil {
+		return nil, fmt.Errorf("create bucket: %s", err)
+	}
+	enc, err := marshal(cur)
+	if err != nil {
+		return nil, err
+	}
+	return added, mb.Put([]byte(pathKey(path)), enc)
+}
+
+// loadIndexes returns indexed fields of bucket
+func loadIndexes(tx *bolt.Tx, path []string) ([]string, error) {
+	return loadMeta(tx, "indexes", path)
+}
+
+// ensureIndexes registers indexes of bucket and builds ones that are new.
+// Encrypted buckets are not indexed so their queries scan records
+func (db *DB) ensureIndexes(tx *bolt.Tx, path []string, fields []string) error {
+	if db.bucketOptions(path).Encrypt {
+		return nil
+	}
+	added, err := extendMeta(tx, "indexes", path, fields)
+	if err != nil || len(added) == 0 {
+		return err
+	}
+
+	b := getBucket(tx, path)
+	if b == nil {
+		return nil
+	}
+	return b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		v, err := db.unpack(path, v)
+		if err != nil {
+			return err
+		}
+		return indexRecord(tx, path, added, string(k), nil, v)
+	})
+}
+
+// updateIndexes updates all indexes of bucket for record id changed
+// from old to enc. nil old means new record, nil enc means deleted record
+func updateIndexes(tx *bolt.Tx, path []string, id string, old, enc []byte) error {
+	fields, err := loadIndexes(tx, path)
+	if err != nil || len(fields) == 0 {
+		return err
+	}
+	return indexRecord(tx, path, fields, id, old, enc)
+}
+
+func indexRecord(tx *bolt.Tx, path []string, fields []string, id string, old, enc []byte) error {
+	a, err := unmarshalMap(old)
+	if err != nil {
+		return err
+	}
+	n, err := unmarshalMap(enc)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fields {
+		ov, oldOk := indexValue(a[f])
+		nv, newOk := indexValue(n[f])
+		if oldOk == newOk && ov == nv {
+			continue
+		}
+		b, err := createBucket(tx, indexPath(path, f))
+		if err != nil {
+			return fmt.Errorf("create index bucket: %s", err)
+		}
+		if oldOk {
+			if err := b.Delete(indexKey(ov, id)); err != nil {
+				return err
+			}
+		}
+		if newOk {
+			if err := b.Put(indexKey(nv, id), []byte{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lookupIndex returns ids of records having field equal to value.
+// ok is false if field is not indexed
+func lookupIndex(tx *bolt.Tx, path []string, field, value string) (ids []string, ok bool, err error) {
+	fields, err := loadIndexes(tx, path)
+	if err != nil {
+		return
+	}
+	if !contains(fields, field) {
+		return
+	}
+	ok = true
+	b := getBucket(tx, indexPath(path, field))
+	if b == nil {
+		return
+	}
+	prefix := indexKey(value, "")
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		ids = append(ids, string(k[len(prefix):]))
+	}
+	return
+}
+
+// findBy returns ids of records having field equal to value.
+// index is used if field is indexed, otherwise bucket is scanned and
+// field is matched case-insensitively if records have no such field
+func (db *DB) findBy(tx *bolt.Tx, path []string, field, value string) ([]string, error) {
+	ids, ok, err := lookupIndex(tx, path, field, value)
+	if err != nil || ok {
+		return ids, err
+	}
+
+	b := getBucket(tx, path)
+	if b == nil {
+		return nil, nil
+	}
+	err = b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		v, err := db.unpack(path, v)
+		if err != nil {
+			return err
+		}
+		rec, err := unmarshalMap(v)
+		if err != nil {
+			return err
+		}
+		if s, ok := indexValue(recordField(rec, field)); ok && s == value {
+			ids = append(ids, string(k))
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// recordField returns value of field name of decoded record. Fields are
+// matched case-insensitively if record has no field name
+func recordField(rec map[string]interface{}, name string) interface{} {
+	if v, ok := rec[name]; ok {
+		return v
+	}
+	for k, v := range rec {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+// encodedIndexValue returns string representation of Go value v as it is
+// indexed once stored
+func encodedIndexValue(v interface{}) (string, bool, error) {
+	if v == nil {
+		return "", false, nil
+	}
+	enc, err := encode(v)
+	if err != nil {
+		return "", false, err
+	}
+	var d interface{}
+	if err := unmarshalValue(enc, &d); err != nil {
+		return "", false, err
+	}
+	s, ok := indexValue(d)
+	return s, ok, nil
+}
+
+// indexValue returns string representation of decoded field value
+func indexValue(v interface{}) (string, bool) {
+	switch t := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return t, true
+	case json.Number:
+		return t.String(), true
+	default:
+		return fmt.Sprint(t), true
+	}
+}
+
+// indexPath returns path of index bucket of field
+func indexPath(path []string, field string) []string {
+	res := make([]string, len(path), len(path)+1)
+	copy(res, path)
+	res[len(res)-1] += indexSuffix
+	return append(res, field)
+}
+
+func indexKey(value, id string) []byte {
+	return append(append([]byte(value), 0), id...)
+}
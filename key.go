@@ -0,0 +1,177 @@
+package borm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Key is a record key encoded so that keys sort in the same order as
+// encoded values. Numbers and times are encoded as fixed-width hex text so
+// keys are valid ids. Keys are used as ids with String and as raw keys with Bytes
+// 		o := Order{}
+// 		o.ID = borm.Uint64Key(42).String()
+// 		db.Save([]string{"orders"}, &o)
+// 		db.Find([]string{"orders"}, borm.Uint64Key(42).String(), &o)
+type Key []byte
+
+// StrKey returns key of string s
+func StrKey(s string) Key {
+	return Key(s)
+}
+
+// Uint64Key returns 16-digit hex key of v
+func Uint64Key(v uint64) Key {
+	return Key(hex.EncodeToString(itob(v)))
+}
+
+// Int64Key returns 16-digit hex key of v with flipped sign bit so negative
+// values sort before positive ones
+func Int64Key(v int64) Key {
+	return Uint64Key(uint64(v) ^ 1<<63)
+}
+
+// TimeKey returns Int64Key of unix time of t in nanoseconds
+func TimeKey(t time.Time) Key {
+	return Int64Key(t.UnixNano())
+}
+
+// TupleKey returns composite key of parts. Tuples sort by parts in order.
+// Parts are terminated with 0x01 0x01, bytes 0x00 and 0x01 are escaped as
+// 0x01 0x02 and 0x01 0x03 so tuple keys have no zero bytes used as separators
+// by borm
+// 		borm.TupleKey(borm.StrKey("user1"), borm.TimeKey(time.Now()))
+func TupleKey(parts ...Key) Key {
+	var res []byte
+	for _, p := range parts {
+		for _, c := range p {
+			if c <= 1 {
+				res = append(res, 1, c+2)
+			} else {
+				res = append(res, c)
+			}
+		}
+		res = append(res, 1, 1)
+	}
+	return Key(res)
+}
+
+// String returns key as string id
+func (k Key) String() string {
+	return string(k)
+}
+
+// Bytes returns key as raw bolt key
+func (k Key) Bytes() []byte {
+	return []byte(k)
+}
+
+// Uint64 decodes key of Uint64Key
+func (k Key) Uint64() uint64 {
+	b, err := hex.DecodeString(string(k))
+	if err != nil || len(b) != 8 {
+		return 0
+	}
+	return btoi(b)
+}
+
+// Int64 decodes key of Int64Key
+func (k Key) Int64() int64 {
+	return int64(k.Uint64() ^ 1<<63)
+}
+
+// Time decodes key of TimeKey
+func (k Key) Time() time.Time {
+	return time.Unix(0, k.Int64()).UTC()
+}
+
+// Tuple decodes parts of TupleKey
+func (k Key) Tuple() ([]Key, error) {
+	var res []Key
+	var part []byte
+	for i := 0; i < len(k); i++ {
+		if k[i] != 1 {
+			part = append(part, k[i])
+			continue
+		}
+		if i++; i == len(k) {
+			return nil, errors.New("invalid tuple key")
+		}
+		switch k[i] {
+		case 2, 3:
+			part = append(part, k[i]-2)
+		case 1:
+			res = append(res, Key(part))
+			part = nil
+		default:
+			return nil, errors.New("invalid tuple key")
+		}
+	}
+	if part != nil {
+		return nil, errors.New("invalid tuple key")
+	}
+	return res, nil
+}
+
+// KeysBytes returns keys as raw keys for ListKeys
+// 		db.ListKeys([]string{"orders"}, borm.KeysBytes(borm.Uint64Key(1), borm.Uint64Key(2)), &res)
+func KeysBytes(keys ...Key) [][]byte {
+	res := make([][]byte, len(keys))
+	for i, k := range keys {
+		res[i] = k.Bytes()
+	}
+	return res
+}
+
+// KeysStrings returns keys as string ids for DeleteKeys
+// 		db.DeleteKeys([]string{"orders"}, borm.KeysStrings(borm.Uint64Key(1), borm.Uint64Key(2)))
+func KeysStrings(keys ...Key) []string {
+	res := make([]string, len(keys))
+	for i, k := range keys {
+		res[i] = k.String()
+	}
+	return res
+}
+
+// ListRange fills models slice with records with keys from from up to to
+// excluding it. Empty to lists records up to the end of bucket
+// 		res := []Order{}
+// 		db.ListRange([]string{"orders"}, borm.Uint64Key(10).String(), borm.Uint64Key(20).String(), &res)
+func (db *DB) ListRange(path []string, from, to string, dest interface{}) error {
+	path = db.scope(path)
+	l := logit(db.Log, "LIST-RANGE", path, "", []string{from, to})
+	err := db.listRange(path, from, to, dest)
+	return l.done(err)
+}
+
+func (db *DB) listRange(path []string, from, to string, dest interface{}) error {
+	if err := db.check(path); err != nil {
+		return err
+	}
+
+	var keys [][]byte
+	err := db.db.View(func(tx *bolt.Tx) error {
+		b := getBucket(tx, path)
+		if b == nil {
+			return db.bucketNotFound(tx, path)
+		}
+		c := b.Cursor()
+		for k, v := c.Seek([]byte(from)); k != nil; k, v = c.Next() {
+			if to != "" && bytes.Compare(k, []byte(to)) >= 0 {
+				break
+			}
+			if v == nil {
+				continue
+			}
+			keys = append(keys, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return db.listKeys(path, keys, dest)
+}
@@ -0,0 +1,208 @@
+package borm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/boltdb/bolt"
+)
+
+// linksBucket is the root bucket holding many-to-many links
+const linksBucket = "_links"
+
+// link join bucket names
+const (
+	linkOut = "out"
+	linkIn  = "in"
+)
+
+// linkEnd is a linked record reference
+type linkEnd struct {
+	Path []string
+	ID   string
+}
+
+// Link links record idA from pathA with record idB from pathB by relation.
+// Links are removed automatically when either record is deleted
+// 		db.Link([]string{"posts"}, post.ID, []string{"tags"}, tag.ID, "tags")
+func (db *DB) Link(pathA []string, idA string, pathB []string, idB string, relation string) error {
+	pathA, pathB = db.scope(pathA), db.scope(pathB)
+	l := logit(db.Log, "LINK", pathA, idA, relation)
+	err := db.link(pathA, idA, pathB, idB, relation, true)
+	return l.done(err)
+}
+
+// Unlink removes link created with Link
+// 		db.Unlink([]string{"posts"}, post.ID, []string{"tags"}, tag.ID, "tags")
+func (db *DB) Unlink(pathA []string, idA string, pathB []string, idB string, relation string) error {
+	pathA, pathB = db.scope(pathA), db.scope(pathB)
+	l := logit(db.Log, "UNLINK", pathA, idA, relation)
+	err := db.link(pathA, idA, pathB, idB, relation, false)
+	return l.done(err)
+}
+
+func (db *DB) link(pathA []string, idA string, pathB []string, idB string, relation string, add bool) error {
+	if err := db.check(pathA); err != nil {
+		return err
+	}
+	if err := db.check(pathB); err != nil {
+		return err
+	}
+
+	return db.db.Update(func(tx *bolt.Tx) error {
+		out, err := createBucket(tx, []string{linksBucket, relation, linkOut})
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		in, err := createBucket(tx, []string{linksBucket, relation, linkIn})
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+
+		a := linkEnd{Path: pathA, ID: idA}
+		b := linkEnd{Path: pathB, ID: idB}
+		if !add {
+			if err := out.Delete(linkKey(a, b)); err != nil {
+				return err
+			}
+			return in.Delete(linkKey(b, a))
+		}
+
+		for _, e := range []linkEnd{a, b} {
+			if rb := getBucket(tx, e.Path); rb == nil || rb.Get([]byte(e.ID)) == nil {
+				return errors.New("Record not found")
+			}
+		}
+		encA, err := marshal(a)
+		if err != nil {
+			return err
+		}
+		encB, err := marshal(b)
+		if err != nil {
+			return err
+		}
+		if err := out.Put(linkKey(a, b), encB); err != nil {
+			return err
+		}
+		return in.Put(linkKey(b, a), encA)
+	})
+}
+
+// Linked fills models slice with records linked to record id from path
+// by relation in either direction
+// 		tags := []Tag{}
+// 		db.Linked([]string{"posts"}, post.ID, "tags", &tags)
+func (db *DB) Linked(path []string, id string, relation string, dest interface{}) error {
+	path = db.scope(path)
+	l := logit(db.Log, "LINKED", path, id, relation)
+	err := db.linked(path, id, relation, dest)
+	return l.done(err)
+}
+
+func (db *DB) linked(path []string, id string, relation string, dest interface{}) error {
+	if err := db.check(path); err != nil {
+		return err
+	}
+
+	return db.db.View(func(tx *bolt.Tx) error {
+		v := reflect.ValueOf(dest)
+		if v.Kind() != reflect.Ptr {
+			return errors.New("expected pointer but value passed")
+		}
+		if v.IsNil() {
+			return errors.New("nil pointer passed")
+		}
+
+		d := reflect.Indirect(v)
+		slice, err := baseType(v.Type(), reflect.Slice)
+		if err != nil {
+			return err
+		}
+
+		ptr := slice.Elem().Kind() == reflect.Ptr
+		tp := deref(slice.Elem())
+
+		prefix := linkPrefix(linkEnd{Path: path, ID: id})
+		for _, dir := range []string{linkOut, linkIn} {
+			b := getBucket(tx, []string{linksBucket, relation, dir})
+			if b == nil {
+				continue
+			}
+			c := b.Cursor()
+			for k, val := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, val = c.Next() {
+				var e linkEnd
+				if err := unmarshal(val, &e); err != nil {
+					return err
+				}
+				rb := getBucket(tx, e.Path)
+				if rb == nil {
+					continue
+				}
+				data, err := db.getRecord(rb, e.Path, e.ID)
+				if err != nil {
+					return err
+				}
+				if data == nil {
+					continue
+				}
+				item := reflect.New(tp)
+				if err := decode(data, item.Interface()); err != nil {
+					return err
+				}
+				if ptr {
+					d.Set(reflect.Append(d, item))
+				} else {
+					d.Set(reflect.Append(d, reflect.Indirect(item)))
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// unlinkAll removes all links of record id from path
+func unlinkAll(tx *bolt.Tx, path []string, id string) error {
+	root := tx.Bucket([]byte(linksBucket))
+	if root == nil {
+		return nil
+	}
+	prefix := linkPrefix(linkEnd{Path: path, ID: id})
+
+	return root.ForEach(func(rel, v []byte) error {
+		rb := root.Bucket(rel)
+		if v != nil || rb == nil {
+			return nil
+		}
+		for _, dir := range [][2]string{{linkOut, linkIn}, {linkIn, linkOut}} {
+			b, other := rb.Bucket([]byte(dir[0])), rb.Bucket([]byte(dir[1]))
+			if b == nil || other == nil {
+				continue
+			}
+			c := b.Cursor()
+			for k, val := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, val = c.Seek(prefix) {
+				var e linkEnd
+				if err := unmarshal(val, &e); err != nil {
+					return err
+				}
+				if err := other.Delete(linkKey(e, linkEnd{Path: path, ID: id})); err != nil {
+					return err
+				}
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// linkPrefix returns key prefix of all links of record e
+func linkPrefix(e linkEnd) []byte {
+	return []byte(pathKey(append(append([]string{}, e.Path...), e.ID)) + "\x01")
+}
+
+func linkKey(a, b linkEnd) []byte {
+	return append(linkPrefix(a), linkPrefix(b)...)
+}
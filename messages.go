@@ -0,0 +1,97 @@
+package borm
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Validation error codes
+const (
+	CodeCustom        = "custom"
+	CodeBlank         = "blank"
+	CodeTooShort      = "too_short"
+	CodeTooLong       = "too_long"
+	CodeTooSmall      = "too_small"
+	CodeTooLarge      = "too_large"
+	CodeInvalidFormat = "invalid_format"
+	CodeInclusion     = "inclusion"
+	CodeExclusion     = "exclusion"
+	CodeTooEarly      = "too_early"
+	CodeTooLate       = "too_late"
+	CodeConfirmation  = "confirmation"
+	CodeTaken         = "taken"
+	CodeUnverified    = "unverified"
+	CodeUnknownRule   = "unknown_rule"
+)
+
+// FieldError is a single validation error of model field
+type FieldError struct {
+	Field  string
+	Code   string
+	Params map[string]interface{}
+	// Message is set for errors with custom code only
+	Message string
+}
+
+// Error returns error message formatted with Formatter
+func (e FieldError) Error() string {
+	return Formatter(e)
+}
+
+// MarshalJSON encodes error with message formatted with Formatter
+// 		{"field":"Name","code":"too_short","params":{"min":3},"message":"minimum length is 3"}
+func (e FieldError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Field   string                 `json:"field"`
+		Code    string                 `json:"code"`
+		Params  map[string]interface{} `json:"params,omitempty"`
+		Message string                 `json:"message"`
+	}{e.Field, e.Code, e.Params, e.Error()})
+}
+
+// MessageFormatter returns message of validation error
+type MessageFormatter func(e FieldError) string
+
+// Formatter is used to build validation messages. Set it to translate messages
+// 		borm.Formatter = func(e borm.FieldError) string {
+// 			return translate(locale, e.Code, e.Params)
+// 		}
+var Formatter MessageFormatter = DefaultMessage
+
+// DefaultMessage returns english message of validation error
+func DefaultMessage(e FieldError) string {
+	switch e.Code {
+	case CodeBlank:
+		return "can't be blank"
+	case CodeTooShort:
+		return fmt.Sprintf("minimum length is %v", e.Params["min"])
+	case CodeTooLong:
+		return fmt.Sprintf("maximum length is %v", e.Params["max"])
+	case CodeTooSmall:
+		return fmt.Sprintf("minimum value is %v", e.Params["min"])
+	case CodeTooLarge:
+		return fmt.Sprintf("maximum value is %v", e.Params["max"])
+	case CodeInvalidFormat:
+		return "invalid format"
+	case CodeInclusion:
+		return "is not included in the list"
+	case CodeExclusion:
+		return "is reserved"
+	case CodeTooEarly:
+		return fmt.Sprintf("must be after %v", e.Params["min"])
+	case CodeTooLate:
+		return fmt.Sprintf("must be before %v", e.Params["max"])
+	case CodeConfirmation:
+		return "doesn't match confirmation"
+	case CodeTaken:
+		return "has already been taken"
+	case CodeUnverified:
+		return "could not be validated"
+	case CodeUnknownRule:
+		return fmt.Sprintf("unknown validation rule %v", e.Params["rule"])
+	}
+	if e.Message != "" {
+		return e.Message
+	}
+	return e.Code
+}
@@ -1,6 +1,7 @@
 package borm
 
 import (
+	"reflect"
 	"strconv"
 	"time"
 )
@@ -8,11 +9,15 @@ import (
 type mod interface {
 	GetID() string
 	setID(id string)
-	Created() time.Time
+}
+
+type modCreate interface {
+	setCreation(t time.Time)
+	creation() time.Time
 }
 
 type modUpdate interface {
-	touchModel()
+	touchModel(t time.Time)
 }
 
 // Model
@@ -31,7 +36,7 @@ func (i *MID) GetID() string {
 	return i.ID
 }
 
-// Created returns creation time
+// Created returns creation time parsed from ID
 func (i *MID) Created() time.Time {
 	u, _ := strconv.ParseInt(i.ID, 10, 64)
 	return time.Unix(0, u)
@@ -41,11 +46,72 @@ func (i *MID) setID(id string) {
 	i.ID = id
 }
 
+// Record is a stored record of bucket having no Model in bucket options.
+// It is passed to events of partial updates of such buckets
+type Record struct {
+	MID
+	Fields map[string]interface{}
+}
+
+// CreateTime struct for managing creation time
+type CreateTime struct {
+	Created time.Time
+}
+
+func (c *CreateTime) setCreation(t time.Time) {
+	if c.Created.IsZero() {
+		c.Created = t
+	}
+}
+
+func (c *CreateTime) creation() time.Time {
+	return c.Created
+}
+
+// keepCreation restores creation time of model m from its stored record old
+func keepCreation(old []byte, m mod) error {
+	rec, err := unmarshalMap(old)
+	if err != nil {
+		return err
+	}
+	s, ok := rec[getTypeInfo(reflect.TypeOf(m)).storageName("Created")].(string)
+	if !ok {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return err
+	}
+	m.(modCreate).setCreation(t.UTC())
+	return nil
+}
+
 // UpdateTime struct for managing update time
 type UpdateTime struct {
 	Updated time.Time
 }
 
-func (u *UpdateTime) touchModel() {
-	u.Updated = time.Now()
+func (u *UpdateTime) touchModel(t time.Time) {
+	u.Updated = t
+}
+
+// Timestamps struct for managing creation and update time.
+// Fields are declared directly so Created field is not shadowed by Model
+type Timestamps struct {
+	Created time.Time
+	Updated time.Time
+}
+
+func (t *Timestamps) setCreation(v time.Time) {
+	if t.Created.IsZero() {
+		t.Created = v
+	}
+}
+
+func (t *Timestamps) creation() time.Time {
+	return t.Created
+}
+
+func (t *Timestamps) touchModel(v time.Time) {
+	t.Updated = v
 }
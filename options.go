@@ -0,0 +1,104 @@
+package borm
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BucketOptions configures optional features of a bucket
+type BucketOptions struct {
+	// History keeps previous versions of records in sibling history bucket
+	History bool
+
+	// SkipUnchanged skips writing records that have no changed fields
+	SkipUnchanged bool
+
+	// Compression compresses values with CompressFast or CompressDense.
+	// Buckets that had compressed or encrypted values are remembered so
+	// their values are unpacked even after compression is unset
+	Compression int
+
+	// CompressThreshold is minimal size of compressed value.
+	// DefaultCompressThreshold is used if not set
+	CompressThreshold int
+
+	// Encrypt encrypts values with keys of DB.Keys
+	Encrypt bool
+
+	// Retention drops records of time-series bucket older than retention
+	Retention time.Duration
+
+	// Rollups are downsampled aggregates of time-series bucket fields
+	Rollups []RollupSpec
+
+	// Model is a sample of model stored in bucket. It is used to build
+	// models for events of operations that don't receive a model
+	// 		borm.BucketOptions{Model: &Person{}}
+	Model mod
+}
+
+type bucketRegistry struct {
+	mu   sync.RWMutex
+	opts map[string]BucketOptions
+	// quotas are quotas of tenants by paths of their root buckets
+	quotas map[string]TenantQuota
+	// packed are paths of buckets having packed values
+	packed map[string]bool
+}
+
+func newBucketRegistry() *bucketRegistry {
+	return &bucketRegistry{
+		opts:   make(map[string]BucketOptions),
+		quotas: make(map[string]TenantQuota),
+		packed: make(map[string]bool),
+	}
+}
+
+// SetBucketOptions sets options for bucket
+// 		db.SetBucketOptions([]string{"people"}, borm.BucketOptions{History: true})
+func (db *DB) SetBucketOptions(path []string, o BucketOptions) {
+	path = db.scope(path)
+	if db.buckets == nil {
+		db.buckets = newBucketRegistry()
+	}
+	db.buckets.mu.Lock()
+	db.buckets.opts[pathKey(path)] = o
+	db.buckets.mu.Unlock()
+}
+
+// GetBucketOptions returns options of bucket
+func (db *DB) GetBucketOptions(path []string) BucketOptions {
+	return db.bucketOptions(db.scope(path))
+}
+
+func (db *DB) bucketOptions(path []string) BucketOptions {
+	if db.buckets == nil {
+		return BucketOptions{}
+	}
+	db.buckets.mu.RLock()
+	defer db.buckets.mu.RUnlock()
+	return db.buckets.opts[pathKey(path)]
+}
+
+// newModel returns new empty model of bucket type or nil if not set
+func (o BucketOptions) newModel() mod {
+	if o.Model == nil {
+		return nil
+	}
+	return reflect.New(reflect.TypeOf(o.Model).Elem()).Interface().(mod)
+}
+
+// typeInfo returns description of bucket model type or nil if not set
+func (o BucketOptions) typeInfo() *typeInfo {
+	if o.Model == nil {
+		return nil
+	}
+	return getTypeInfo(reflect.TypeOf(o.Model))
+}
+
+// pathKey returns unique string representation of path
+func pathKey(path []string) string {
+	return strings.Join(path, "\x00")
+}
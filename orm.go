@@ -1,10 +1,13 @@
 package borm
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/boltdb/bolt"
 	"github.com/vtg/pubsub"
@@ -29,8 +32,25 @@ type DB struct {
 	File string
 	Log  bool
 
-	db   *bolt.DB
-	open bool
+	// ChangeLog enables recording of all mutations into change log
+	ChangeLog bool
+
+	// Now returns current time used for timestamps. time.Now is used if not set
+	Now func() time.Time
+
+	// Keys provides keys for encrypted buckets and fields
+	Keys KeyProvider
+
+	db       *bolt.DB
+	open     bool
+	feed     *changeFeed
+	buckets  *bucketRegistry
+	views    *viewRegistry
+	ctx      context.Context
+	preloads []string
+
+	// prefix is path of tenant root bucket of tenant handles
+	prefix []string
 }
 
 // Open opens database
@@ -41,6 +61,12 @@ func Open(dbfile string) (db DB, err error) {
 	}
 	db.open = true
 	db.File = dbfile
+	db.feed = newChangeFeed()
+	db.buckets = newBucketRegistry()
+	db.views = newViewRegistry()
+	if err = db.loadPacked(); err != nil {
+		db.Close()
+	}
 	return
 }
 
@@ -48,12 +74,16 @@ func Open(dbfile string) (db DB, err error) {
 func (db *DB) Close() {
 	db.open = false
 	db.db.Close()
+	if db.feed != nil {
+		db.feed.notify()
+	}
 }
 
 // Find returns model from database
 // 		m := Model{}
 // 		db.Find([]string{"bucket"}, &m)
 func (db *DB) Find(path []string, id string, i interface{}) error {
+	path = db.scope(modelPath(path, i))
 	l := logit(db.Log, "FIND", path, id, nil)
 	err := db.find(path, id, i)
 	return l.done(err)
@@ -68,20 +98,24 @@ func (db *DB) find(path []string, id string, i interface{}) error {
 		var err error
 		b := getBucket(tx, path)
 		if b == nil {
-			return errors.New("Bucket not found")
+			return db.bucketNotFound(tx, path)
 		}
 
-		k := []byte(id)
-		if err = unmarshal(b.Get(k), i); err != nil {
+		v, err := db.getRecord(b, path, id)
+		if err != nil {
 			return err
 		}
-		return nil
+		if err = decode(v, i); err != nil {
+			return err
+		}
+		return db.preload(tx, path, i)
 	})
 }
 
 // GET returns value by key
 // 		val, err := db.FindValue([]string{"bucket"}, "1")
 func (db *DB) Get(path []string, key string) ([]byte, error) {
+	path = db.scope(path)
 	l := logit(db.Log, "GET", path, key, nil)
 	v, err := db.get(path, key)
 	return v, l.done(err)
@@ -96,18 +130,23 @@ func (db *DB) get(path []string, key string) ([]byte, error) {
 	err := db.db.View(func(tx *bolt.Tx) error {
 		b := getBucket(tx, path)
 		if b == nil {
-			return errors.New("Bucket not found")
+			return db.bucketNotFound(tx, path)
 		}
-		v = b.Get([]byte(key))
-		return nil
+		var err error
+		v, err = db.unpackValue(path, b.Get([]byte(key)))
+		return err
 	})
 	return v, err
 }
 
-// Save saves model into database
+// Save saves model into database.
+// Models having validate struct tags are validated before saving.
+// nil path uses BucketPath of model
 // 		m := Model{Name: "Model Name"}
 // 		db.Save([]string{"bucket"}, &m)
+// 		db.Save(nil, &m)
 func (db *DB) Save(path []string, m mod) error {
+	path = db.scope(modelPath(path, m))
 	l := logit(db.Log, "SAVE", path, "", m)
 	err := db.save(path, m)
 	return l.done(err)
@@ -117,35 +156,219 @@ func (db *DB) save(path []string, m mod) error {
 	if err := db.check(path); err != nil {
 		return err
 	}
+	if err := validateModel(m); err != nil {
+		return err
+	}
 
 	return db.db.Update(func(tx *bolt.Tx) error {
+		return db.saveModel(tx, path, m)
+	})
+}
+
+// validateModel validates models having validate struct tags
+func validateModel(m mod) error {
+	if v, ok := m.(validatable); ok && hasValidation(reflect.TypeOf(m)) {
+		v.getValidator().ResetErrors()
+		if !Validate(v) {
+			return ErrInvalid
+		}
+	}
+	return nil
+}
+
+// saveModel saves model in transaction tx. Models without stored record
+// are saved as new ones even if their id is preset
+func (db *DB) saveModel(tx *bolt.Tx, path []string, m mod) error {
+	if !utf8.ValidString(m.GetID()) {
+		return fmt.Errorf("invalid id %q: ids must be valid UTF-8", m.GetID())
+	}
+	if strings.IndexByte(m.GetID(), 0) >= 0 {
+		return fmt.Errorf("invalid id %q: ids must not contain zero bytes", m.GetID())
+	}
+	b, err := createBucket(tx, path)
+	if err != nil {
+		return fmt.Errorf("create bucket: %s", err)
+	}
+
+	var old []byte
+	var diff Diff
+	if m.GetID() != "" {
+		if old, err = db.getRecord(b, path, m.GetID()); err != nil {
+			return err
+		}
+	}
+	if old != nil {
+		if m1, ok := m.(modCreate); ok && m1.creation().IsZero() {
+			if err := keepCreation(old, m); err != nil {
+				return fmt.Errorf("could not decode %s: %s", m.GetID(), err)
+			}
+		}
+		if diff, err = diffRecord(old, m); err != nil {
+			return fmt.Errorf("could not compare %s: %s", m.GetID(), err)
+		}
+		if len(diff) == 0 && db.bucketOptions(path).SkipUnchanged {
+			return nil
+		}
+	}
+
+	now := db.now()
+	id := checkID(m, now)
+	newItem := old == nil
+	if m1, ok := m.(modCreate); ok && newItem {
+		m1.setCreation(now)
+	}
+
+	enc, err := encode(m)
+	if err != nil {
+		return fmt.Errorf("could not encode %s: %s", id, err)
+	}
+
+	info := getTypeInfo(reflect.TypeOf(m))
+	if info.err != nil {
+		return info.err
+	}
+	if err := db.ensureIndexes(tx, path, info.indexes); err != nil {
+		return err
+	}
+	if _, err := extendMeta(tx, "encrypted", path, info.encrypted); err != nil {
+		return err
+	}
+	if err := db.ensureFulltext(tx, path, info.fulltext); err != nil {
+		return err
+	}
+	if err := db.ensureGeo(tx, path, info.geo); err != nil {
+		return err
+	}
+
+	if err := db.putRecord(tx, b, path, "update", id, old, enc); err != nil {
+		return err
+	}
+
+	if newItem {
+		addEvent("Created", m)
+	} else {
+		addEvent("Updated", m, diff)
+	}
+	return nil
+}
+
+// Exists returns true if bucket has record with id
+// 		ok, err := db.Exists([]string{"bucket"}, "1")
+func (db *DB) Exists(path []string, id string) (bool, error) {
+	path = db.scope(path)
+	l := logit(db.Log, "EXISTS", path, id, nil)
+	ok, err := db.exists(path, id)
+	return ok, l.done(err)
+}
+
+func (db *DB) exists(path []string, id string) (ok bool, err error) {
+	if err = db.check(path); err != nil {
+		return
+	}
+
+	err = db.db.View(func(tx *bolt.Tx) error {
+		if b := getBucket(tx, path); b != nil {
+			ok = b.Get([]byte(id)) != nil
+		}
+		return nil
+	})
+	return
+}
+
+// FindOrCreate finds model by id or creates it with this id if it doesn't
+// exist. init is called to fill new model before saving.
+// Returns true if model was created
+// 		m := Model{}
+// 		created, err := db.FindOrCreate([]string{"bucket"}, "1", &m, func() { m.Name = "Name" })
+func (db *DB) FindOrCreate(path []string, id string, m mod, init func()) (bool, error) {
+	path = db.scope(modelPath(path, m))
+	l := logit(db.Log, "FIND-OR-CREATE", path, id, nil)
+	created, err := db.findOrCreate(path, id, m, init)
+	return created, l.done(err)
+}
+
+func (db *DB) findOrCreate(path []string, id string, m mod, init func()) (created bool, err error) {
+	if err = db.check(path); err != nil {
+		return
+	}
+
+	err = db.db.Update(func(tx *bolt.Tx) error {
 		b, err := createBucket(tx, path)
 		if err != nil {
 			return fmt.Errorf("create bucket: %s", err)
 		}
-
-		id, newItem := checkID(m)
-
-		enc, err := marshal(m)
+		v, err := db.getRecord(b, path, id)
 		if err != nil {
-			return fmt.Errorf("could not encode %s: %s", id, err)
+			return err
+		}
+		if v != nil {
+			if err := decode(v, m); err != nil {
+				return err
+			}
+			return db.preload(tx, path, m)
 		}
 
-		if err := b.Put([]byte(id), enc); err != nil {
+		m.setID(id)
+		if init != nil {
+			init()
+		}
+		if err := validateModel(m); err != nil {
 			return err
 		}
+		created = true
+		return db.saveModel(tx, path, m)
+	})
+	if err != nil {
+		created = false
+	}
+	return
+}
+
+// Upsert saves model merging it with stored one. merge is called with
+// stored model and m if record with id of m exists and should update m.
+// Model with id that doesn't exist is created
+// 		m := Model{Name: "Name"}
+// 		m.ID = "1"
+// 		db.Upsert([]string{"bucket"}, &m, func(existing, incoming interface{}) {
+// 			incoming.(*Model).Visits += existing.(*Model).Visits
+// 		})
+func (db *DB) Upsert(path []string, m mod, merge func(existing, incoming interface{})) error {
+	path = db.scope(modelPath(path, m))
+	l := logit(db.Log, "UPSERT", path, m.GetID(), m)
+	err := db.upsert(path, m, merge)
+	return l.done(err)
+}
+
+func (db *DB) upsert(path []string, m mod, merge func(existing, incoming interface{})) error {
+	if err := db.check(path); err != nil {
+		return err
+	}
 
-		if newItem {
-			addEvent("Created", m)
-		} else {
-			addEvent("Updated", m)
+	return db.db.Update(func(tx *bolt.Tx) error {
+		var v []byte
+		if b := getBucket(tx, path); b != nil && m.GetID() != "" {
+			var err error
+			if v, err = db.getRecord(b, path, m.GetID()); err != nil {
+				return err
+			}
 		}
-		return nil
+		if v != nil && merge != nil {
+			existing := reflect.New(reflect.TypeOf(m).Elem()).Interface()
+			if err := decode(v, existing); err != nil {
+				return fmt.Errorf("could not decode %s: %s", m.GetID(), err)
+			}
+			merge(existing, m)
+		}
+		if err := validateModel(m); err != nil {
+			return err
+		}
+		return db.saveModel(tx, path, m)
 	})
 }
 
 // SaveValue saves key/value pair into database
 func (db *DB) SaveValue(path []string, id string, val []byte) error {
+	path = db.scope(path)
 	l := logit(db.Log, "SAVE-VALUE", path, "", val)
 	err := db.saveValue(path, id, val)
 	return l.done(err)
@@ -161,7 +384,14 @@ func (db *DB) saveValue(path []string, id string, val []byte) error {
 		if err != nil {
 			return fmt.Errorf("create bucket: %s", err)
 		}
-		return b.Put([]byte(id), val)
+		enc, err := db.pack(path, val)
+		if err != nil {
+			return err
+		}
+		if err := db.putCounted(tx, b, path, id, enc); err != nil {
+			return err
+		}
+		return db.logChange(tx, OpPut, path, id, val)
 	})
 }
 
@@ -170,16 +400,29 @@ func (db *DB) saveValue(path []string, id string, val []byte) error {
 // 		db.Find([]string{"bucket"}, &m)
 // 		db.Delete([]string{"bucket"}, &m)
 func (db *DB) Delete(path []string, m mod) error {
-	err := db.DeleteKeys(path, []string{m.GetID()})
+	path = db.scope(modelPath(path, m))
+	l := logit(db.Log, "Delete", path, m.GetID(), nil)
+	err := db.delete(path, m)
 	if err == nil {
 		addEvent("Deleted", m)
 	}
-	return err
+	return l.done(err)
+}
+
+func (db *DB) delete(path []string, m mod) error {
+	if err := db.check(path); err != nil {
+		return err
+	}
+
+	return db.db.Update(func(tx *bolt.Tx) error {
+		return db.deleteModel(tx, path, m.GetID(), reflect.TypeOf(m))
+	})
 }
 
 // DeleteKeys deletes records from database by keys
 // 		db.DeleteKeys([]string{"bucket"}, []string{"1","2","3"})
 func (db *DB) DeleteKeys(path []string, keys []string) error {
+	path = db.scope(path)
 	l := logit(db.Log, "Delete", path, "", keys)
 	err := db.deleteKeys(path, keys)
 	return l.done(err)
@@ -193,10 +436,19 @@ func (db *DB) deleteKeys(path []string, keys []string) error {
 	return db.db.Update(func(tx *bolt.Tx) error {
 		b := getBucket(tx, path)
 		if b == nil {
-			return fmt.Errorf("Bucket not found")
+			return db.bucketNotFound(tx, path)
 		}
 		for _, v := range keys {
-			b.Delete([]byte(v))
+			old, err := db.getRecord(b, path, v)
+			if err != nil {
+				return err
+			}
+			if old == nil {
+				continue
+			}
+			if err := db.deleteRecord(tx, b, path, v, old); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
@@ -205,6 +457,7 @@ func (db *DB) deleteKeys(path []string, keys []string) error {
 // DeleteBuckets deletes records from database by keys
 // 		db.DeleteBuckets([]string{"bucket"}, []string{"1","2","3"})
 func (db *DB) DeleteBuckets(path []string, keys []string) error {
+	path = db.scope(path)
 	l := logit(db.Log, "DELETE BUCKET", path, "", keys)
 	err := db.deleteBuckets(path, keys)
 	return l.done(err)
@@ -218,12 +471,18 @@ func (db *DB) deleteBuckets(path []string, keys []string) error {
 	return db.db.Update(func(tx *bolt.Tx) error {
 		b := getBucket(tx, path)
 		if b == nil {
-			return fmt.Errorf("Bucket not found")
+			return db.bucketNotFound(tx, path)
 		}
 		for _, v := range keys {
 			if err := b.DeleteBucket([]byte(v)); err != nil {
 				return err
 			}
+			if err := dropMeta(tx, append(path[:len(path):len(path)], v)); err != nil {
+				return err
+			}
+			if err := db.logChange(tx, OpDeleteBucket, path, v, nil); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
@@ -235,6 +494,7 @@ func (db *DB) deleteBuckets(path []string, keys []string) error {
 // load with params
 // 		db.List([]string{"bucket"}, &m, Params{Offset: 10, Limit: 30})
 func (db *DB) List(path []string, dest interface{}, params ...Params) error {
+	path = db.scope(modelPath(path, dest))
 	l := logit(db.Log, "LIST", path, "", params)
 	err := db.list(path, dest, params...)
 	return l.done(err)
@@ -250,7 +510,7 @@ func (db *DB) list(path []string, dest interface{}, params ...Params) error {
 	return db.db.View(func(tx *bolt.Tx) error {
 		b := getBucket(tx, path)
 		if b == nil {
-			return fmt.Errorf("Bucket not found")
+			return db.bucketNotFound(tx, path)
 		}
 
 		v := reflect.ValueOf(dest)
@@ -277,8 +537,15 @@ func (db *DB) list(path []string, dest interface{}, params ...Params) error {
 				continue
 			}
 			i++
+			v, err := db.unpack(path, v)
+			if err != nil {
+				return err
+			}
 			item := reflect.New(tp)
-			if err := unmarshal(v, item.Interface()); err != nil && err.Error() != "unexpected end of JSON input" {
+			if err := decode(v, item.Interface()); err != nil && err.Error() != "unexpected end of JSON input" {
+				return err
+			}
+			if err := db.preload(tx, path, item.Interface()); err != nil {
 				return err
 			}
 
@@ -296,6 +563,7 @@ func (db *DB) list(path []string, dest interface{}, params ...Params) error {
 // 		m := []Model{}
 // 		db.ListKeys([]string{"bucket"}, [][]byte{[]byte("1"),[]byte("2")}, &m)
 func (db *DB) ListKeys(path []string, keys [][]byte, dest interface{}) error {
+	path = db.scope(path)
 	l := logit(db.Log, "LISTKEYS", path, "", nil)
 	err := db.listKeys(path, keys, dest)
 	return l.done(err)
@@ -309,7 +577,7 @@ func (db *DB) listKeys(path []string, keys [][]byte, dest interface{}) error {
 	return db.db.View(func(tx *bolt.Tx) error {
 		b := getBucket(tx, path)
 		if b == nil {
-			return fmt.Errorf("Bucket not found")
+			return db.bucketNotFound(tx, path)
 		}
 
 		v := reflect.ValueOf(dest)
@@ -330,12 +598,18 @@ func (db *DB) listKeys(path []string, keys [][]byte, dest interface{}) error {
 		tp := deref(slice.Elem())
 
 		for _, key := range keys {
-			v := b.Get(key)
+			v, err := db.getRecord(b, path, string(key))
+			if err != nil {
+				return err
+			}
 			if v == nil {
 				continue
 			}
 			item := reflect.New(tp)
-			if err := unmarshal(v, item.Interface()); err != nil && err.Error() != "unexpected end of JSON input" {
+			if err := decode(v, item.Interface()); err != nil && err.Error() != "unexpected end of JSON input" {
+				return err
+			}
+			if err := db.preload(tx, path, item.Interface()); err != nil {
 				return err
 			}
 
@@ -351,6 +625,7 @@ func (db *DB) listKeys(path []string, keys [][]byte, dest interface{}) error {
 
 // ListItems returns raw records from database
 func (db *DB) ListItems(path []string, params ...Params) (map[string][]byte, error) {
+	path = db.scope(path)
 	l := logit(db.Log, "LIST", path, "", params)
 	res := make(map[string][]byte)
 	err := db.listItems(path, res, params...)
@@ -367,7 +642,7 @@ func (db *DB) listItems(path []string, res map[string][]byte, params ...Params)
 	err := db.db.View(func(tx *bolt.Tx) error {
 		b := getBucket(tx, path)
 		if b == nil {
-			return fmt.Errorf("Bucket not found")
+			return db.bucketNotFound(tx, path)
 		}
 
 		i := 0
@@ -377,6 +652,10 @@ func (db *DB) listItems(path []string, res map[string][]byte, params ...Params)
 				continue
 			}
 			i++
+			v, err := db.unpackValue(path, v)
+			if err != nil {
+				return err
+			}
 			res[string(k)] = v
 		}
 
@@ -387,6 +666,7 @@ func (db *DB) listItems(path []string, res map[string][]byte, params ...Params)
 
 // Values returns values from bucket
 func (db *DB) Values(path []string, params ...Params) ([][]byte, error) {
+	path = db.scope(path)
 	l := logit(db.Log, "VALUES", path, "", params)
 	res, err := db.values(path, params...)
 	return res, l.done(err)
@@ -402,7 +682,7 @@ func (db *DB) values(path []string, params ...Params) (res [][]byte, err error)
 	err = db.db.View(func(tx *bolt.Tx) error {
 		b := getBucket(tx, path)
 		if b == nil {
-			return fmt.Errorf("Bucket not found")
+			return db.bucketNotFound(tx, path)
 		}
 
 		i := 0
@@ -412,7 +692,11 @@ func (db *DB) values(path []string, params ...Params) (res [][]byte, err error)
 				continue
 			}
 			i++
-			res = append(res, v)
+			v, err := db.unpackValue(path, v)
+			if err != nil {
+				return err
+			}
+			res = append(res, append([]byte{}, v...))
 		}
 
 		return nil
@@ -420,23 +704,20 @@ func (db *DB) values(path []string, params ...Params) (res [][]byte, err error)
 	return
 }
 
-// Count returns number of records in bucket
+// Count returns number of records in bucket or 0 on error. Use CountE
+// to get the error
 func (db *DB) Count(path []string) int {
-	res := 0
+	path = db.scope(path)
+	res, _ := db.count(path)
+	return res
+}
 
-	if err := db.check(path); err != nil {
-		return res
+// now returns current time in UTC
+func (db *DB) now() time.Time {
+	if db.Now != nil {
+		return db.Now().UTC()
 	}
-
-	db.db.View(func(tx *bolt.Tx) error {
-		b := getBucket(tx, path)
-		if b == nil {
-			return fmt.Errorf("Bucket not found")
-		}
-		res = b.Stats().KeyN
-		return nil
-	})
-	return res
+	return time.Now().UTC()
 }
 
 func (db *DB) check(path []string) error {
@@ -449,6 +730,67 @@ func (db *DB) check(path []string) error {
 	return nil
 }
 
+// putRecord stores record encoding enc replacing old one and keeps
+// history, indexes and change log in sync
+func (db *DB) putRecord(tx *bolt.Tx, b *bolt.Bucket, path []string, op, id string, old, enc []byte) error {
+	if err := db.checkDerived(tx, path); err != nil {
+		return err
+	}
+	if err := db.archive(tx, path, id, op, old); err != nil {
+		return err
+	}
+	if err := updateIndexes(tx, path, id, old, enc); err != nil {
+		return err
+	}
+	if err := updateFulltext(tx, path, id, old, enc); err != nil {
+		return err
+	}
+	if err := updateGeo(tx, path, id, old, enc); err != nil {
+		return err
+	}
+	if err := db.updateViews(tx, path, id, old, enc); err != nil {
+		return err
+	}
+	val, err := db.sealFields(tx, path, enc)
+	if err != nil {
+		return err
+	}
+	if val, err = db.pack(path, val); err != nil {
+		return err
+	}
+	if err := db.putCounted(tx, b, path, id, val); err != nil {
+		return err
+	}
+	return db.logChange(tx, OpPut, path, id, enc)
+}
+
+// deleteRecord deletes record with encoding old and keeps
+// history, indexes, links and change log in sync
+func (db *DB) deleteRecord(tx *bolt.Tx, b *bolt.Bucket, path []string, id string, old []byte) error {
+	if err := db.archive(tx, path, id, "delete", old); err != nil {
+		return err
+	}
+	if err := updateIndexes(tx, path, id, old, nil); err != nil {
+		return err
+	}
+	if err := updateFulltext(tx, path, id, old, nil); err != nil {
+		return err
+	}
+	if err := updateGeo(tx, path, id, old, nil); err != nil {
+		return err
+	}
+	if err := db.updateViews(tx, path, id, old, nil); err != nil {
+		return err
+	}
+	if err := unlinkAll(tx, path, id); err != nil {
+		return err
+	}
+	if err := db.deleteCounted(tx, b, path, id); err != nil {
+		return err
+	}
+	return db.logChange(tx, OpDelete, path, id, nil)
+}
+
 func parseParams(p []Params) Params {
 	r := Params{
 		Offset: 0,
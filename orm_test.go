@@ -1,16 +1,22 @@
 package borm
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"path"
 	"reflect"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/boltdb/bolt"
 	"github.com/vtg/pubsub"
 )
 
@@ -248,6 +254,1308 @@ func TestCount(t *testing.T) {
 	assertEqual(t, 100, res)
 }
 
+func TestChangeLogWatch(t *testing.T) {
+	openDB()
+	db.ChangeLog = true
+	defer func() { db.ChangeLog = false }()
+
+	p := Person{Name: "John Doe"}
+	db.Save([]string{"cdc"}, &p)
+	db.SaveValue([]string{"cdc-other"}, "1", []byte("1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := db.Watch(ctx, 0, []string{"cdc"})
+
+	next := func() Change {
+		select {
+		case c := <-ch:
+			return c
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for change")
+		}
+		return Change{}
+	}
+
+	c := next()
+	assertEqual(t, OpPut, c.Op)
+	assertEqual(t, []string{"cdc"}, c.Path)
+	assertEqual(t, p.ID, c.Key)
+
+	db.Delete([]string{"cdc"}, &p)
+	c1 := next()
+	assertEqual(t, OpDelete, c1.Op)
+	assertEqual(t, p.ID, c1.Key)
+	assertEqual(t, true, c1.Seq > c.Seq)
+
+	assertEqual(t, nil, db.TrimChangeLog(c1.Seq))
+	changes, _ := db.changesFrom(0)
+	assertEqual(t, 1, len(changes))
+	assertEqual(t, c1.Seq, changes[0].Seq)
+}
+
+func TestHistory(t *testing.T) {
+	openDB()
+	path := []string{"hist"}
+	db.SetBucketOptions(path, BucketOptions{History: true})
+
+	p := Person{Name: "John Doe"}
+	db.Save(path, &p)
+	p.Name = "Jane Doe"
+	db.WithContext(WithActor(context.Background(), "admin")).Save(path, &p)
+	db.Delete(path, &p)
+
+	revs, err := db.History(path, p.ID)
+	assertEqual(t, nil, err)
+	assertEqual(t, 2, len(revs))
+	assertEqual(t, uint64(1), revs[0].Rev)
+	assertEqual(t, "update", revs[0].Op)
+	assertEqual(t, "admin", revs[0].Actor)
+	assertEqual(t, "delete", revs[1].Op)
+
+	p1 := Person{}
+	db.Revision(path, p.ID, 1, &p1)
+	assertEqual(t, "John Doe", p1.Name)
+
+	assertEqual(t, nil, db.Revert(path, p.ID, 2))
+	p2 := Person{}
+	db.Find(path, p.ID, &p2)
+	assertEqual(t, "Jane Doe", p2.Name)
+}
+
+func TestChanges(t *testing.T) {
+	openDB()
+	path := []string{"diff"}
+	db.SetBucketOptions(path, BucketOptions{SkipUnchanged: true})
+
+	p := Person{Name: "John Doe"}
+	db.Save(path, &p)
+
+	p.Name = "Jane Doe"
+	p.Active = true
+	d, err := db.Changes(path, &p)
+	assertEqual(t, nil, err)
+	assertEqual(t, Diff{
+		{Field: "Active", Old: false, New: true},
+		{Field: "Name", Old: "John Doe", New: "Jane Doe"},
+	}, d)
+	assertEqual(t, true, d.Changed("Name"))
+	db.Save(path, &p)
+
+	v, _ := db.Get(path, p.ID)
+	db.Save(path, &p)
+	v1, _ := db.Get(path, p.ID)
+	assertEqual(t, string(v), string(v1))
+}
+
+func TestUpdateFields(t *testing.T) {
+	openDB()
+	path := []string{"patch"}
+
+	p := Person{Name: "John Doe"}
+	db.Save(path, &p)
+
+	assertEqual(t, nil, db.UpdateFields(path, p.ID, map[string]interface{}{"Active": true}))
+	p1 := Person{}
+	db.Find(path, p.ID, &p1)
+	assertEqual(t, true, p1.Active)
+	assertEqual(t, "John Doe", p1.Name)
+	assertEqual(t, true, p1.Updated.After(p.Updated))
+
+	assertEqual(t, nil, db.Patch(path, p.ID, []byte(`{"Name":"Jane Doe","ID":"1"}`)))
+	p2 := Person{}
+	db.Find(path, p.ID, &p2)
+	assertEqual(t, "Jane Doe", p2.Name)
+	assertEqual(t, p.ID, p2.ID)
+	assertEqual(t, true, p2.Active)
+
+	assertEqual(t, "Record not found", db.UpdateFields(path, "missing", nil).Error())
+
+	var events []string
+	var rec *Record
+	defer func(f func(string, mod, ...interface{})) { addEvent = f }(addEvent)
+	addEvent = func(name string, m mod, objs ...interface{}) {
+		events = append(events, eventName(name, m))
+		rec, _ = m.(*Record)
+	}
+	assertEqual(t, nil, db.UpdateFields(path, p.ID, map[string]interface{}{"Home": TaggedAddress{City: "B"}}))
+	v, _ := db.Get(path, p.ID)
+	assertEqual(t, true, strings.Contains(string(v), `"Home":{"city":"B"}`))
+	assertEqual(t, []string{"RecordUpdated"}, events)
+	assertEqual(t, p.ID, rec.ID)
+	assertEqual(t, map[string]interface{}{"city": "B"}, rec.Fields["Home"])
+}
+
+type TaggedAddress struct {
+	City string `borm:"city"`
+}
+
+type Ticket struct {
+	Model
+	Title   string    `borm:"title" validate:"required"`
+	Updated time.Time `borm:"updated_at"`
+}
+
+func TestUpdateFieldsModel(t *testing.T) {
+	openDB()
+	path := []string{"tickets"}
+	db.SetBucketOptions(path, BucketOptions{Model: &Ticket{}})
+
+	tk := Ticket{Title: "bug"}
+	assertEqual(t, nil, db.Save(path, &tk))
+	assertEqual(t, nil, db.UpdateFields(path, tk.ID, map[string]interface{}{"Title": "fix"}))
+	tk1 := Ticket{}
+	db.Find(path, tk.ID, &tk1)
+	assertEqual(t, "fix", tk1.Title)
+	assertEqual(t, false, tk1.Updated.IsZero())
+
+	assertEqual(t, ErrInvalid, db.UpdateFields(path, tk.ID, map[string]interface{}{"Title": ""}))
+	assertEqual(t, true, strings.HasPrefix(db.UpdateFields(path, tk.ID, map[string]interface{}{"Title": 1}).Error(), "could not decode "+tk.ID))
+	assertEqual(t, true, strings.HasPrefix(db.Patch(path, tk.ID, []byte(`{"title":[]}`)).Error(), "could not decode "+tk.ID))
+	tk1 = Ticket{}
+	db.Find(path, tk.ID, &tk1)
+	assertEqual(t, "fix", tk1.Title)
+}
+
+func TestCounters(t *testing.T) {
+	openDB()
+	path := []string{"counters"}
+
+	n, err := db.Incr(path, "c", 5)
+	assertEqual(t, nil, err)
+	assertEqual(t, int64(5), n)
+	n, _ = db.Decr(path, "c", 7)
+	assertEqual(t, int64(-2), n)
+
+	var wg sync.WaitGroup
+	for k := 0; k < 50; k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db.Incr(path, "c1", 1)
+		}()
+	}
+	wg.Wait()
+	v, _ := db.Get(path, "c1")
+	assertEqual(t, "50", string(v))
+
+	ok, _ := db.CompareAndSwap(path, "lock", nil, []byte("a"))
+	assertEqual(t, true, ok)
+	ok, _ = db.CompareAndSwap(path, "lock", nil, []byte("b"))
+	assertEqual(t, false, ok)
+	ok, _ = db.CompareAndSwap(path, "lock", []byte("a"), nil)
+	assertEqual(t, true, ok)
+
+	s, _ := db.NextSequence(path)
+	s1, _ := db.NextSequence(path)
+	assertEqual(t, s+1, s1)
+}
+
+type User struct {
+	Model
+
+	Name   string
+	Orders []Order `borm:"hasMany:orders,onDelete=cascade"`
+}
+
+type Order struct {
+	Model
+
+	Amount int
+	UserID string
+	User   *User `borm:"belongsTo:users"`
+}
+
+type Customer struct {
+	Model
+
+	Orders []*Order `borm:"hasMany:orders,fk=UserID,onDelete=restrict"`
+}
+
+func TestRelations(t *testing.T) {
+	openDB()
+	users := []string{"rel", "users"}
+	orders := []string{"rel", "orders"}
+
+	u := User{Name: "John Doe"}
+	db.Save(users, &u)
+	o := Order{Amount: 10, UserID: u.ID}
+	db.Save(orders, &o)
+	o1 := Order{Amount: 20, UserID: u.ID}
+	db.Save(orders, &o1)
+	db.Save(orders, &Order{Amount: 30, UserID: "other"})
+
+	v, _ := db.Get(users, u.ID)
+	assertEqual(t, false, strings.Contains(string(v), "Amount"))
+
+	u1 := User{}
+	assertEqual(t, nil, db.Preload("Orders").Find(users, u.ID, &u1))
+	assertEqual(t, 2, len(u1.Orders))
+	assertEqual(t, 10, u1.Orders[0].Amount)
+
+	o2 := Order{}
+	db.Preload("User").Find(orders, o.ID, &o2)
+	assertEqual(t, "John Doe", o2.User.Name)
+
+	assertEqual(t, "unknown relation Items", db.Preload("Items").Find(users, u.ID, &u1).Error())
+
+	c := Customer{}
+	c.ID = u.ID
+	assertEqual(t, "Customer "+u.ID+" has related Orders", db.Delete(users, &c).Error())
+
+	assertEqual(t, nil, db.Delete(users, &u))
+	o3 := Order{}
+	db.Find(orders, o.ID, &o3)
+	assertEqual(t, "", o3.ID)
+	assertEqual(t, 1, db.Count(orders))
+}
+
+type Owner struct {
+	Model
+
+	Orders []Order `borm:"hasMany:nested/users/{id}/orders,onDelete=cascade"`
+}
+
+func TestNestedRelations(t *testing.T) {
+	openDB()
+	owners := []string{"nested", "owners"}
+	w := Owner{}
+	db.Save(owners, &w)
+	orders := []string{"nested", "users", w.ID, "orders"}
+	db.Save(orders, &Order{Amount: 10})
+	db.Save(orders, &Order{Amount: 20})
+	db.Save([]string{"nested", "users", "other", "orders"}, &Order{Amount: 30})
+
+	w1 := Owner{}
+	assertEqual(t, nil, db.Preload("Orders").Find(owners, w.ID, &w1))
+	assertEqual(t, 2, len(w1.Orders))
+	assertEqual(t, 10, w1.Orders[0].Amount)
+
+	assertEqual(t, nil, db.Delete(owners, &w))
+	assertEqual(t, 0, db.Count(orders))
+	assertEqual(t, 1, db.Count([]string{"nested", "users", "other", "orders"}))
+}
+
+func TestLinks(t *testing.T) {
+	openDB()
+	posts := []string{"links", "posts"}
+	tags := []string{"links", "tags"}
+
+	p := Person{Name: "Post"}
+	db.Save(posts, &p)
+	t1 := Person{Name: "go"}
+	db.Save(tags, &t1)
+	t2 := Person{Name: "bolt"}
+	db.Save(tags, &t2)
+
+	assertEqual(t, nil, db.Link(posts, p.ID, tags, t1.ID, "tags"))
+	assertEqual(t, nil, db.Link(posts, p.ID, tags, t2.ID, "tags"))
+	assertEqual(t, "Record not found", db.Link(posts, p.ID, tags, "missing", "tags").Error())
+
+	res := []Person{}
+	db.Linked(posts, p.ID, "tags", &res)
+	assertEqual(t, 2, len(res))
+
+	res1 := []*Person{}
+	db.Linked(tags, t1.ID, "tags", &res1)
+	assertEqual(t, 1, len(res1))
+	assertEqual(t, "Post", res1[0].Name)
+
+	db.Unlink(posts, p.ID, tags, t1.ID, "tags")
+	res = []Person{}
+	db.Linked(posts, p.ID, "tags", &res)
+	assertEqual(t, []string{"bolt"}, []string{res[0].Name})
+
+	db.DeleteKeys(tags, []string{t2.ID})
+	res = []Person{}
+	db.Linked(posts, p.ID, "tags", &res)
+	assertEqual(t, 0, len(res))
+}
+
+func TestValidators(t *testing.T) {
+	openDB()
+	p := Person{}
+	p.ValidateLength("Name", "abc", 0, 2)
+	p.ValidateInt("Age", -1, 0, 10)
+	p.ValidateEmail("Email", "john@example.com")
+	p.ValidateEmail("Email1", "john.example.com")
+	p.ValidateURL("URL", "http://example.com/a")
+	p.ValidateURL("URL1", "example")
+	p.ValidateUUID("UUID", "123e4567-e89b-12d3-a456-426614174000")
+	p.ValidateInclusion("Status", "new", "new", "done")
+	p.ValidateInclusion("Status1", "old", "new", "done")
+	p.ValidateExclusion("Login", "admin", "admin", "root")
+	p.ValidateTime("Time", time.Now(), time.Now().Add(time.Hour), time.Time{})
+	p.ValidateConfirmation("Password", "a", "b")
+	assertEqual(t, Errors{
+		"Name":     {"maximum length is 2"},
+		"Age":      {"minimum value is 0"},
+		"Email1":   {"invalid format"},
+		"URL1":     {"invalid format"},
+		"Status1":  {"is not included in the list"},
+		"Login":    {"is reserved"},
+		"Time":     p.GetErrors()["Time"],
+		"Password": {"doesn't match confirmation"},
+	}, p.GetErrors())
+	assertEqual(t, 1, len(p.GetErrors()["Time"]))
+
+	path := []string{"unique"}
+	p1 := Person{Name: "John Doe"}
+	db.Save(path, &p1)
+	p1.ValidateUniqueness(&db, path, "Name", p1.Name)
+	assertEqual(t, true, p1.Valid())
+	p2 := Person{Name: "John Doe"}
+	p2.ValidateUniqueness(&db, path, "Name", p2.Name)
+	assertEqual(t, Errors{"Name": {"has already been taken"}}, p2.GetErrors())
+
+	members := []string{"members"}
+	joined := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	m1 := Member{Email: "a@b.c", Login: "ab", Joined: joined}
+	db.Save(members, &m1)
+	m2 := Member{}
+	m2.ValidateUniqueness(&db, members, "Email", m1.Email)
+	m2.ValidateUniqueness(&db, members, "Joined", joined)
+	m2.ValidateUniqueness(&db, members, "Login", m1.Login)
+	assertEqual(t, Errors{"Email": {"has already been taken"}, "Joined": {"has already been taken"}}, m2.GetErrors())
+
+	db.SetBucketOptions(members, BucketOptions{Model: &Member{}})
+	m2 = Member{}
+	m2.ValidateUniqueness(&db, members, "Login", m1.Login)
+	assertEqual(t, Errors{"Login": {"has already been taken"}}, m2.GetErrors())
+}
+
+type Member struct {
+	Model
+	Email  string `json:"email"`
+	Login  string `borm:"login_name"`
+	Joined time.Time
+}
+
+type Address struct {
+	City string `validate:"required"`
+}
+
+type Account struct {
+	Model
+
+	Name      string `validate:"required,min=3,max=5"`
+	Email     string `validate:"format=email"`
+	Role      string `validate:"oneof=admin|user"`
+	Age       int    `validate:"min=18"`
+	Address   Address
+	Addresses []*Address `validate:"max=1"`
+}
+
+func TestValidate(t *testing.T) {
+	openDB()
+	a := Account{Name: "Jo", Email: "jo", Role: "root", Age: 10, Addresses: []*Address{{}, {City: "A"}}}
+	assertEqual(t, false, Validate(&a))
+	assertEqual(t, Errors{
+		"Name":              {"minimum length is 3"},
+		"Email":             {"invalid format"},
+		"Role":              {"is not included in the list"},
+		"Age":               {"minimum value is 18"},
+		"Address.City":      {"can't be blank"},
+		"Addresses":         {"maximum length is 1"},
+		"Addresses[0].City": {"can't be blank"},
+	}, a.GetErrors())
+
+	assertEqual(t, ErrInvalid, db.Save([]string{"accounts"}, &a))
+	assertEqual(t, "", a.ID)
+
+	a = Account{Name: "John", Email: "jo@example.com", Role: "user", Age: 20, Address: Address{City: "A"}}
+	assertEqual(t, nil, db.Save([]string{"accounts"}, &a))
+	assertEqual(t, true, a.Valid())
+}
+
+func TestErrorMessages(t *testing.T) {
+	p := Person{}
+	p.ValidateLength("Name", "ab", 3, -1)
+	p.AddError("Age", "is wrong")
+	assertEqual(t, Errors{"Name": {"minimum length is 3"}, "Age": {"is wrong"}}, p.GetErrors())
+	assertEqual(t, CodeTooShort, p.FieldErrors()[0].Code)
+
+	enc, _ := json.Marshal(p.FieldErrors())
+	assertEqual(t, `[{"field":"Name","code":"too_short","params":{"min":3},"message":"minimum length is 3"},`+
+		`{"field":"Age","code":"custom","message":"is wrong"}]`, string(enc))
+
+	ru := func(e FieldError) string {
+		if e.Code == CodeTooShort {
+			return fmt.Sprintf("минимальная длина %v", e.Params["min"])
+		}
+		return DefaultMessage(e)
+	}
+	assertEqual(t, Errors{"Name": {"минимальная длина 3"}, "Age": {"is wrong"}}, p.FormatErrors(ru))
+
+	p.SetErrors(Errors{"Name": {"a"}})
+	assertEqual(t, Errors{"Name": {"a"}}, p.GetErrors())
+	p.ResetErrors()
+	assertEqual(t, true, p.Valid())
+}
+
+type Profile struct {
+	Model
+
+	Email    string `json:"email" borm:"e"`
+	Token    string `json:"token" borm:"-"`
+	Nick     string `json:"nick,omitempty"`
+	Bio      string `borm:"omitempty"`
+	Internal string `json:"-" borm:"Internal"`
+	Address  *Address
+	Tags     map[string]Address
+}
+
+func TestStorageTags(t *testing.T) {
+	openDB()
+	path := []string{"profiles"}
+
+	p := Profile{Email: "a@b.c", Token: "secret", Internal: "i", Address: &Address{City: "A"}, Tags: map[string]Address{"x": {City: "B"}}}
+	p.ID = "1"
+	assertEqual(t, nil, db.Save(path, &p))
+
+	v, _ := db.Get(path, p.ID)
+	assertEqual(t, `{"ID":"1","e":"a@b.c","Internal":"i","Address":{"City":"A"},"Tags":{"x":{"City":"B"}}}`, string(v))
+
+	p1 := Profile{}
+	db.Find(path, p.ID, &p1)
+	assertEqual(t, "a@b.c", p1.Email)
+	assertEqual(t, "", p1.Token)
+	assertEqual(t, "i", p1.Internal)
+	assertEqual(t, "A", p1.Address.City)
+	assertEqual(t, "B", p1.Tags["x"].City)
+}
+
+type Note struct {
+	Model
+	Text string
+	Timestamps
+}
+
+func TestTimestamps(t *testing.T) {
+	openDB()
+	defer func() { db.Now = nil }()
+	path := []string{"notes"}
+
+	t1 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("X", 3600))
+	db.Now = func() time.Time { return t1 }
+	n := Note{Text: "a"}
+	assertEqual(t, nil, db.Save(path, &n))
+	assertEqual(t, t1.UTC(), n.Created)
+	assertEqual(t, time.UTC, n.Created.Location())
+	assertEqual(t, t1.UTC(), n.Updated)
+
+	t2 := t1.Add(time.Hour)
+	db.Now = func() time.Time { return t2 }
+	n1 := Note{Text: "b"}
+	n1.ID = n.ID
+	assertEqual(t, nil, db.Save(path, &n1))
+	assertEqual(t, t1.UTC(), n1.Created)
+	assertEqual(t, t2.UTC(), n1.Updated)
+
+	n2 := Note{}
+	db.Find(path, n.ID, &n2)
+	assertEqual(t, t1.UTC(), n2.Created)
+	assertEqual(t, t2.UTC(), n2.Updated)
+}
+
+func TestSavePresetID(t *testing.T) {
+	openDB()
+	defer func() { db.Now = nil }()
+	path := []string{"notes"}
+	var events []string
+	defer func(f func(string, mod, ...interface{})) { addEvent = f }(addEvent)
+	addEvent = func(name string, m mod, objs ...interface{}) {
+		events = append(events, eventName(name, m))
+	}
+
+	t1 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	db.Now = func() time.Time { return t1 }
+	n := Note{Text: "a"}
+	n.ID = "preset"
+	assertEqual(t, nil, db.Save(path, &n))
+	assertEqual(t, t1, n.Created)
+	assertEqual(t, t1, n.Updated)
+
+	n1 := Note{Text: "b"}
+	n1.ID = "preset"
+	assertEqual(t, nil, db.Save(path, &n1))
+	assertEqual(t, t1, n1.Created)
+	assertEqual(t, []string{eventName("Created", &n), eventName("Updated", &n)}, events)
+}
+
+func TestCompression(t *testing.T) {
+	openDB()
+	for _, c := range []int{CompressFast, CompressDense} {
+		path := []string{"compressed" + strconv.Itoa(c)}
+		rawPath := []string{"compressed_raw" + strconv.Itoa(c)}
+		db.SetBucketOptions(path, BucketOptions{Compression: c, CompressThreshold: 200})
+		db.SetBucketOptions(rawPath, BucketOptions{Compression: c})
+
+		p := Person{Name: strings.Repeat("John ", 100)}
+		assertEqual(t, nil, db.Save(path, &p))
+		small := Person{Name: "Joe"}
+		assertEqual(t, nil, db.Save(path, &small))
+		assertEqual(t, nil, db.SaveValue(rawPath, "raw", []byte{1, 2, 3}))
+
+		db.db.View(func(tx *bolt.Tx) error {
+			b := getBucket(tx, path)
+			assertEqual(t, byte(c), b.Get([]byte(p.ID))[0])
+			assertEqual(t, true, len(b.Get([]byte(p.ID))) < len(p.Name))
+			assertEqual(t, byte('{'), b.Get([]byte(small.ID))[0])
+			assertEqual(t, []byte{0, 1, 2, 3}, getBucket(tx, rawPath).Get([]byte("raw")))
+			return nil
+		})
+
+		p1 := Person{}
+		assertEqual(t, nil, db.Find(path, p.ID, &p1))
+		assertEqual(t, p.Name, p1.Name)
+		v, _ := db.Get(rawPath, "raw")
+		assertEqual(t, []byte{1, 2, 3}, v)
+		vals, _ := db.Values(rawPath)
+		assertEqual(t, [][]byte{{1, 2, 3}}, vals)
+
+		res := []Person{}
+		assertEqual(t, nil, db.List(path, &res))
+		assertEqual(t, 2, len(res))
+		assertEqual(t, p.Name, res[0].Name)
+
+		// values are unpacked after reopen without bucket options
+		db.Close()
+		openDB()
+		p1 = Person{}
+		assertEqual(t, nil, db.Find(path, p.ID, &p1))
+		assertEqual(t, p.Name, p1.Name)
+		v, _ = db.Get(rawPath, "raw")
+		assertEqual(t, []byte{1, 2, 3}, v)
+		assertEqual(t, nil, db.SaveValue(rawPath, "raw", []byte{1, 2, 3}))
+		v, _ = db.Get(rawPath, "raw")
+		assertEqual(t, []byte{1, 2, 3}, v)
+	}
+
+	plain := []string{"uncompressed_raw"}
+	db.SaveValue(plain, "1", []byte{1, 2, 3})
+	db.SaveValue(plain, "2", []byte{3, 2, 1})
+	assertEqual(t, nil, db.DeleteKeys(plain, []string{"1"}))
+	n, err := db.Truncate(plain)
+	assertEqual(t, nil, err)
+	assertEqual(t, 1, n)
+}
+
+type Secret struct {
+	Model
+	Name  string
+	Email string `borm:"email,encrypt"`
+}
+
+type SearchableSecret struct {
+	Model
+	Email string `borm:"email,encrypt,fulltext"`
+}
+
+func TestEncryption(t *testing.T) {
+	openDB()
+	keys := NewKeyRing()
+	keys.Add("k1", []byte(strings.Repeat("1", 32)))
+	db.Keys = keys
+	defer func() { db.Keys = nil }()
+
+	people := []string{"encrypted_people"}
+	secrets := []string{"secrets"}
+	db.SetBucketOptions(people, BucketOptions{Encrypt: true, History: true})
+
+	p := Person{Name: "John"}
+	assertEqual(t, nil, db.Save(people, &p))
+	p.Name = "Johnny"
+	assertEqual(t, nil, db.Save(people, &p))
+	err := db.DefineView("secret_emails", secrets, func(id string, rec map[string]interface{}, emit func(string, interface{})) {
+		emit(fmt.Sprint(rec["Name"]), rec["email"])
+	})
+	assertEqual(t, nil, err)
+	s := Secret{Name: "Jane", Email: "jane@example.com"}
+	assertEqual(t, nil, db.Save(secrets, &s))
+	rows, _ := db.QueryView("secret_emails")
+	assertEqual(t, []ViewRow{{"Jane", s.ID, json.RawMessage("null")}}, rows)
+	ss := SearchableSecret{Email: "jane@example.com"}
+	assertEqual(t, "SearchableSecret.email: encrypted field can't be indexed", db.Save(secrets, &ss).Error())
+
+	raw := func(path []string, id string) (v []byte) {
+		db.db.View(func(tx *bolt.Tx) error {
+			v = append(v, getBucket(tx, path).Get([]byte(id))...)
+			return nil
+		})
+		return
+	}
+	assertEqual(t, headerSealed, raw(people, p.ID)[0])
+	assertEqual(t, false, strings.Contains(string(raw(people, p.ID)), "Johnny"))
+	assertEqual(t, true, strings.Contains(string(raw(secrets, s.ID)), "Jane"))
+	assertEqual(t, false, strings.Contains(string(raw(secrets, s.ID)), "jane@"))
+
+	p1 := Person{}
+	assertEqual(t, nil, db.Find(people, p.ID, &p1))
+	assertEqual(t, "Johnny", p1.Name)
+	db.Revision(people, p.ID, 1, &p1)
+	assertEqual(t, "John", p1.Name)
+	list := []Secret{}
+	assertEqual(t, nil, db.List(secrets, &list))
+	assertEqual(t, "jane@example.com", list[0].Email)
+	list = []Secret{}
+	assertEqual(t, nil, db.ListKeys(secrets, [][]byte{[]byte(s.ID)}, &list))
+	assertEqual(t, "jane@example.com", list[0].Email)
+
+	rotated := NewKeyRing()
+	rotated.Add("k2", []byte(strings.Repeat("2", 32)))
+	keys.Add("k2", []byte(strings.Repeat("2", 32)))
+	n, err := db.Incr(people, "visits", 5)
+	assertEqual(t, nil, err)
+	assertEqual(t, int64(5), n)
+	assertEqual(t, headerSealed, raw(people, "visits")[0])
+	n, _ = db.Incr(people, "visits", 1)
+	assertEqual(t, int64(6), n)
+
+	// sizes of records change when they are compressed on rekey
+	db.SetBucketOptions(people, BucketOptions{Encrypt: true, History: true, Compression: CompressDense, CompressThreshold: 1})
+	assertEqual(t, nil, db.Rekey(people))
+	assertEqual(t, nil, db.Rekey(secrets))
+	db.Keys = rotated
+	db.db.View(func(tx *bolt.Tx) error {
+		b := getBucket(tx, people)
+		var size int64
+		b.ForEach(func(k, v []byte) error {
+			size += int64(len(v))
+			return nil
+		})
+		cb := getBucket(tx, []string{metaBucket, countsBucket})
+		assertEqual(t, size, int64(btoi(cb.Get([]byte(pathKey(people)))[8:])))
+		return nil
+	})
+
+	p1 = Person{}
+	assertEqual(t, nil, db.Find(people, p.ID, &p1))
+	assertEqual(t, "Johnny", p1.Name)
+	db.Revision(people, p.ID, 1, &p1)
+	assertEqual(t, "John", p1.Name)
+	s1 := Secret{}
+	assertEqual(t, nil, db.Find(secrets, s.ID, &s1))
+	assertEqual(t, "jane@example.com", s1.Email)
+
+	db.Keys = NewKeyRing()
+	assertEqual(t, "Key k2 not found", db.Find(people, p.ID, &p1).Error())
+}
+
+func TestEncryptedDerived(t *testing.T) {
+	openDB()
+	keys := NewKeyRing()
+	keys.Add("k1", []byte(strings.Repeat("1", 32)))
+	db.Keys = keys
+	defer func() { db.Keys = nil }()
+
+	orders := []string{"sealed_orders"}
+	articles := []string{"sealed_articles"}
+	db.SetBucketOptions(orders, BucketOptions{Encrypt: true})
+	db.SetBucketOptions(articles, BucketOptions{Encrypt: true})
+
+	o := Order{Amount: 5, UserID: "u1"}
+	assertEqual(t, nil, db.Save(orders, &o))
+	db.db.View(func(tx *bolt.Tx) error {
+		assertEqual(t, true, getBucket(tx, indexPath(orders, "UserID")) == nil)
+		return nil
+	})
+	n, err := db.CountWhere(orders, Cond{Field: "UserID", Op: "=", Value: "u1"})
+	assertEqual(t, nil, err)
+	assertEqual(t, 1, n)
+
+	a := Article{Title: "secretword"}
+	assertEqual(t, "Bucket sealed_articles is encrypted and can't have fulltext", db.Save(articles, &a).Error())
+	err = db.DefineView("sealed", orders, func(id string, rec map[string]interface{}, emit func(string, interface{})) {})
+	assertEqual(t, "Bucket sealed_orders is encrypted and can't have views", err.Error())
+}
+
+type Article struct {
+	Model
+	Title string `borm:"fulltext"`
+	Body  string `borm:"fulltext"`
+}
+
+func TestSearch(t *testing.T) {
+	openDB()
+	path := []string{"articles"}
+
+	a1 := Article{Title: "John Doe", Body: "The story of John"}
+	a2 := Article{Title: "Jane Doe", Body: "Unknown person"}
+	a3 := Article{Title: "Cooking", Body: "Recipes for dinner"}
+	for _, a := range []*Article{&a1, &a2, &a3} {
+		assertEqual(t, nil, db.Save(path, a))
+	}
+
+	titles := func(query string, o SearchOptions) (res []string) {
+		list := []Article{}
+		assertEqual(t, nil, db.Search(path, query, &list, o))
+		for _, a := range list {
+			res = append(res, a.Title)
+		}
+		return
+	}
+	assertEqual(t, []string{"John Doe", "Jane Doe"}, titles("john doe", SearchOptions{}))
+	assertEqual(t, []string{"Jane Doe"}, titles("JANE", SearchOptions{}))
+	assertEqual(t, []string{"John Doe"}, titles("john doe", SearchOptions{Limit: 1}))
+	assertEqual(t, []string{"Cooking"}, titles("cook", SearchOptions{Prefix: true}))
+	assertEqual(t, []string{"Cooking"}, titles("dinnr", SearchOptions{Fuzzy: 1}))
+	assertEqual(t, []string(nil), titles("dinnr", SearchOptions{}))
+
+	a3.Title = "Baking"
+	assertEqual(t, nil, db.Save(path, &a3))
+	assertEqual(t, []string(nil), titles("cooking", SearchOptions{}))
+	assertEqual(t, []string{"Baking"}, titles("baking", SearchOptions{}))
+
+	assertEqual(t, nil, db.Delete(path, &a1))
+	assertEqual(t, []string{"Jane Doe"}, titles("john doe", SearchOptions{}))
+}
+
+type Venue struct {
+	Model
+	Name     string
+	Location Point `borm:"geo"`
+}
+
+func TestGeo(t *testing.T) {
+	openDB()
+	path := []string{"venues"}
+
+	assertEqual(t, "u4pruydqqvj", geohash(57.64911, 10.40744, 11))
+	assertEqual(t, true, math.Abs(distance(52.52, 13.405, 48.8566, 2.3522)-877000) < 2000)
+
+	venues := []Venue{
+		{Name: "Alexanderplatz", Location: Point{52.5219, 13.4132}},
+		{Name: "Brandenburger Tor", Location: Point{52.5163, 13.3777}},
+		{Name: "Potsdam", Location: Point{52.3906, 13.0645}},
+		{Name: "Paris", Location: Point{48.8566, 2.3522}},
+	}
+	for i := range venues {
+		assertEqual(t, nil, db.Save(path, &venues[i]))
+	}
+
+	names := func(res []Venue) (r []string) {
+		for _, v := range res {
+			r = append(r, v.Name)
+		}
+		return
+	}
+	res := []Venue{}
+	assertEqual(t, nil, db.Near(path, 52.52, 13.405, 3000, &res))
+	assertEqual(t, []string{"Alexanderplatz", "Brandenburger Tor"}, names(res))
+	res = []Venue{}
+	db.Near(path, 52.52, 13.405, 50000, &res, Params{Limit: 1})
+	assertEqual(t, []string{"Alexanderplatz"}, names(res))
+	res = []Venue{}
+	db.Near(path, 52.52, 13.405, 1000000, &res)
+	assertEqual(t, 4, len(res))
+	res = []Venue{}
+	db.WithinBox(path, 52.3, 13.0, 52.45, 13.2, &res)
+	assertEqual(t, []string{"Potsdam"}, names(res))
+
+	venues[0].Location = Point{48.86, 2.35}
+	db.Save(path, &venues[0])
+	db.Delete(path, &venues[1])
+	res = []Venue{}
+	db.Near(path, 52.52, 13.405, 3000, &res)
+	assertEqual(t, []string(nil), names(res))
+
+	fiji := []string{"fiji"}
+	for _, v := range []Venue{{Name: "East", Location: Point{0, 179.99}}, {Name: "West", Location: Point{0, -179.99}}, {Name: "Far", Location: Point{0, 170}}} {
+		assertEqual(t, nil, db.Save(fiji, &v))
+	}
+	res = []Venue{}
+	assertEqual(t, nil, db.Near(fiji, 0, 179.99, 5000, &res))
+	assertEqual(t, []string{"East", "West"}, names(res))
+	res = []Venue{}
+	db.Near(fiji, 0, -179.99, 5000, &res)
+	assertEqual(t, []string{"West", "East"}, names(res))
+	res = []Venue{}
+	db.WithinBox(fiji, -1, 179, 1, -179, &res)
+	assertEqual(t, 2, len(res))
+	res = []Venue{}
+	db.Near(fiji, 89.99, 0, 5000, &res)
+	assertEqual(t, []string(nil), names(res))
+}
+
+type Sample struct {
+	Value float64
+}
+
+func TestTimeSeries(t *testing.T) {
+	openDB()
+	defer func() { db.Now = nil }()
+	path := []string{"cpu"}
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	db.Now = func() time.Time { return t0.Add(3 * time.Hour) }
+	db.SetBucketOptions(path, BucketOptions{
+		Retention: 2 * time.Hour,
+		Rollups:   []RollupSpec{{Field: "Value", Interval: time.Hour}},
+	})
+
+	for i, v := range []float64{1, 3, 5, 2, 4, 6} {
+		assertEqual(t, nil, db.Append(path, t0.Add(time.Duration(i)*30*time.Minute), Sample{v}))
+	}
+	assertEqual(t, nil, db.Append(path, t0.Add(150*time.Minute), Sample{8}))
+
+	res := []Sample{}
+	assertEqual(t, nil, db.Range(path, t0.Add(time.Hour), t0.Add(2*time.Hour), &res))
+	assertEqual(t, []Sample{{5}, {2}}, res)
+	res = []Sample{}
+	db.Range(path, t0, t0.Add(5*time.Hour), &res)
+	assertEqual(t, []Sample{{5}, {2}, {4}, {6}, {8}}, res)
+
+	rollups := []Rollup{}
+	assertEqual(t, nil, db.Range(RollupPath(path, "Value", time.Hour), t0, t0.Add(5*time.Hour), &rollups))
+	assertEqual(t, 3, len(rollups))
+	assertEqual(t, Rollup{Start: t0, Count: 2, Min: 1, Max: 3, Sum: 4, Avg: 2}, rollups[0])
+	assertEqual(t, Rollup{Start: t0.Add(2 * time.Hour), Count: 3, Min: 4, Max: 8, Sum: 18, Avg: 6}, rollups[2])
+
+	old := []string{"old_samples"}
+	t1 := time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC)
+	db.Append(old, t0, Sample{2})
+	db.Append(old, t1, Sample{1})
+	db.Append(old, t1, Sample{3})
+	res = []Sample{}
+	assertEqual(t, nil, db.Range(old, t1, t0.Add(time.Hour), &res))
+	assertEqual(t, []Sample{{1}, {3}, {2}}, res)
+	v, err := db.Get(old, TimeKey(t1).String())
+	assertEqual(t, nil, err)
+	assertEqual(t, `{"Value":1}`, string(v))
+}
+
+type Invoice struct {
+	Model
+	Status string
+	Amount float64
+}
+
+func TestAggregate(t *testing.T) {
+	openDB()
+	path := []string{"invoices"}
+	for _, i := range []Invoice{{Status: "paid", Amount: 10}, {Status: "new", Amount: 5}, {Status: "paid", Amount: 30}, {Status: "new", Amount: 1}} {
+		assertEqual(t, nil, db.Save(path, &i))
+	}
+
+	type total struct {
+		Status    string
+		SumAmount float64
+		MaxAmount float64
+		Avg       float64
+		Count     int
+	}
+	res := []total{}
+	assertEqual(t, nil, db.Aggregate(path).GroupBy("Status").Sum("Amount").Max("Amount").Avg("Amount").As("Avg").Count().Run(&res))
+	assertEqual(t, []total{{"new", 6, 5, 3, 2}, {"paid", 40, 30, 20, 2}}, res)
+
+	res = []total{}
+	assertEqual(t, nil, db.Aggregate(path).Where("Amount", ">=", 5).Sum("Amount").Count().Run(&res))
+	assertEqual(t, []total{{SumAmount: 45, Count: 3}}, res)
+
+	rows := []map[string]interface{}{}
+	db.Aggregate(path).Where("Status", "=", "new").Min("Amount").Run(&rows)
+	assertEqual(t, []map[string]interface{}{{"MinAmount": float64(1)}}, rows)
+
+	type byAmount struct {
+		Amount float64
+		Count  int
+	}
+	amounts := []byAmount{}
+	db.Aggregate(path).GroupBy("Amount").Count().Run(&amounts)
+	assertEqual(t, []byAmount{{1, 1}, {5, 1}, {10, 1}, {30, 1}}, amounts)
+
+	db.Save(path, &Invoice{Status: "<nil>"})
+	db.SaveValue(path, "raw", []byte(`{"Amount":2}`))
+	rows = []map[string]interface{}{}
+	db.Aggregate(path).Where("Amount", "<", 5).GroupBy("Status").Count().Run(&rows)
+	assertEqual(t, []map[string]interface{}{
+		{"Status": nil, "Count": float64(1)},
+		{"Status": "<nil>", "Count": float64(1)},
+		{"Status": "new", "Count": float64(1)},
+	}, rows)
+}
+
+func TestViews(t *testing.T) {
+	openDB()
+	path := []string{"view_invoices"}
+	i1 := Invoice{Status: "paid", Amount: 10}
+	assertEqual(t, nil, db.Save(path, &i1))
+
+	err := db.DefineView("by_status", path, func(id string, rec map[string]interface{}, emit func(string, interface{})) {
+		emit(fmt.Sprint(rec["Status"]), rec["Amount"])
+	})
+	assertEqual(t, nil, err)
+
+	i2 := Invoice{Status: "new", Amount: 5}
+	assertEqual(t, nil, db.Save(path, &i2))
+	rows, err := db.QueryView("by_status")
+	assertEqual(t, nil, err)
+	assertEqual(t, []ViewRow{{"new", i2.ID, json.RawMessage("5")}, {"paid", i1.ID, json.RawMessage("10")}}, rows)
+
+	i2.Status = "paid"
+	db.Save(path, &i2)
+	db.Delete(path, &i1)
+	rows, _ = db.QueryView("by_status", Params{Limit: 10})
+	assertEqual(t, []ViewRow{{"paid", i2.ID, json.RawMessage("5")}}, rows)
+
+	assertEqual(t, nil, db.RebuildView("by_status"))
+	rows, _ = db.QueryView("by_status")
+	assertEqual(t, 1, len(rows))
+	assertEqual(t, "View none not found", db.RebuildView("none").Error())
+
+	err = db.DefineView("by_status", path, func(id string, rec map[string]interface{}, emit func(string, interface{})) {
+		emit("amount", rec["Amount"])
+	})
+	assertEqual(t, nil, err)
+	rows, _ = db.QueryView("by_status")
+	assertEqual(t, []ViewRow{{"amount", i2.ID, json.RawMessage("5")}}, rows)
+
+	db.DefineView("bad_keys", path, func(id string, rec map[string]interface{}, emit func(string, interface{})) {
+		emit(fmt.Sprint(rec["Status"]), nil)
+	})
+	i3 := Invoice{Status: "a\x00b"}
+	assertEqual(t, "view bad_keys: invalid key \"a\\x00b\": keys must not contain zero bytes", db.Save(path, &i3).Error())
+}
+
+func TestCountWhere(t *testing.T) {
+	openDB()
+	path := []string{"count", "orders"}
+	_, err := db.CountE(path)
+	assertEqual(t, "Bucket count not found", err.Error())
+
+	for i := 0; i < 5; i++ {
+		o := Order{Amount: i, UserID: fmt.Sprint(i % 2)}
+		assertEqual(t, nil, db.Save(path, &o))
+	}
+	db.SaveValue(path, "raw", []byte(`{"Amount":10}`))
+	n, err := db.CountE(path)
+	assertEqual(t, nil, err)
+	assertEqual(t, 6, n)
+
+	n, err = db.CountWhere(path, Cond{Field: "UserID", Op: "=", Value: "0"})
+	assertEqual(t, nil, err)
+	assertEqual(t, 3, n)
+	n, _ = db.CountWhere(path, Cond{Field: "UserID", Op: "=", Value: "0"}, Cond{Field: "Amount", Op: ">", Value: 0})
+	assertEqual(t, 2, n)
+	n, _ = db.CountWhere(path, Cond{Field: "Amount", Op: ">=", Value: 3})
+	assertEqual(t, 3, n)
+
+	db.DeleteKeys(path, []string{"raw", "none"})
+	db.CompareAndSwap(path, "cas", nil, []byte("1"))
+	db.Incr(path, "seq", 1)
+	assertEqual(t, 7, db.Count(path))
+	db.DeleteBuckets([]string{"count"}, []string{"orders"})
+	assertEqual(t, 0, db.Count(path))
+}
+
+type Shipment struct {
+	Model
+	Status string `borm:"state,index"`
+}
+
+func TestIndexTag(t *testing.T) {
+	openDB()
+	path := []string{"shipments"}
+	for _, st := range []string{"paid", "new", "paid"} {
+		assertEqual(t, nil, db.Save(path, &Shipment{Status: st}))
+	}
+	db.db.View(func(tx *bolt.Tx) error {
+		fields, _ := loadIndexes(tx, path)
+		assertEqual(t, []string{"state"}, fields)
+		ids, ok, _ := lookupIndex(tx, path, "state", "paid")
+		assertEqual(t, true, ok)
+		assertEqual(t, 2, len(ids))
+		return nil
+	})
+	n, err := db.CountWhere(path, Cond{Field: "state", Op: "=", Value: "paid"})
+	assertEqual(t, nil, err)
+	assertEqual(t, 2, n)
+}
+
+func TestUpsert(t *testing.T) {
+	openDB()
+	path := []string{"upsert"}
+	var events []string
+	defer func(f func(string, mod, ...interface{})) { addEvent = f }(addEvent)
+	addEvent = func(name string, m mod, objs ...interface{}) {
+		events = append(events, eventName(name, m))
+	}
+
+	ok, err := db.Exists(path, "1")
+	assertEqual(t, nil, err)
+	assertEqual(t, false, ok)
+
+	p := Person{}
+	created, err := db.FindOrCreate(path, "1", &p, func() { p.Name = "John" })
+	assertEqual(t, nil, err)
+	assertEqual(t, true, created)
+	assertEqual(t, "1", p.ID)
+	ok, _ = db.Exists(path, "1")
+	assertEqual(t, true, ok)
+
+	p1 := Person{}
+	created, _ = db.FindOrCreate(path, "1", &p1, func() { p1.Name = "Jane" })
+	assertEqual(t, false, created)
+	assertEqual(t, "John", p1.Name)
+
+	p2 := Person{Active: true}
+	p2.ID = "1"
+	assertEqual(t, nil, db.Upsert(path, &p2, func(existing, incoming interface{}) {
+		incoming.(*Person).Name = existing.(*Person).Name + " Doe"
+	}))
+	p3 := Person{Name: "Jane"}
+	p3.ID = "2"
+	assertEqual(t, nil, db.Upsert(path, &p3, nil))
+
+	res := []Person{}
+	db.List(path, &res)
+	assertEqual(t, 2, len(res))
+	assertEqual(t, "John Doe", res[0].Name)
+	assertEqual(t, true, res[0].Active)
+	assertEqual(t, []string{"PersonCreated", "PersonUpdated", "PersonCreated"}, events)
+}
+
+func TestBulkDelete(t *testing.T) {
+	openDB()
+	path := []string{"bulk", "orders"}
+	users := []string{"bulk", "users"}
+	_, err := db.Truncate(path)
+	assertEqual(t, "Bucket bulk not found", err.Error())
+
+	var events int
+	defer func(f func(string, mod, ...interface{})) { addEvent = f }(addEvent)
+	addEvent = func(name string, m mod, objs ...interface{}) {
+		if name == "Deleted" {
+			events++
+		}
+	}
+	db.SetBucketOptions(path, BucketOptions{Model: &Order{}})
+	defer db.SetBucketOptions(path, BucketOptions{})
+
+	for i := 0; i < 2500; i++ {
+		o := Order{Amount: i, UserID: fmt.Sprint(i % 2)}
+		o.ID = fmt.Sprintf("%04d", i)
+		db.Save(path, &o)
+	}
+
+	n, err := db.DeleteRange(path, "0100", "0200")
+	assertEqual(t, nil, err)
+	assertEqual(t, 100, n)
+	n, _ = db.DeletePrefix(path, "00")
+	assertEqual(t, 100, n)
+	n, _ = db.DeleteWhere(path, func(key string, value []byte) bool {
+		o := Order{}
+		decode(value, &o)
+		return o.UserID == "1"
+	})
+	assertEqual(t, 1150, n)
+	assertEqual(t, 1150, db.Count(path))
+	c, _ := db.CountWhere(path, Cond{Field: "UserID", Op: "=", Value: "1"})
+	assertEqual(t, 0, c)
+
+	n, _ = db.Truncate(path)
+	assertEqual(t, 1150, n)
+	assertEqual(t, 0, db.Count(path))
+	c, _ = db.CountWhere(path, Cond{Field: "UserID", Op: "=", Value: "0"})
+	assertEqual(t, 0, c)
+	assertEqual(t, 2500, events)
+	db.Save(users, &User{})
+	n, _ = db.Truncate(users)
+	assertEqual(t, 1, n)
+
+	customers := []string{"bulk", "customers"}
+	orders := []string{"bulk", "orders"}
+	db.SetBucketOptions(customers, BucketOptions{Model: &Customer{}})
+	defer db.SetBucketOptions(customers, BucketOptions{})
+	cu := Customer{}
+	db.Save(customers, &cu)
+	db.Save(orders, &Order{UserID: cu.ID})
+	events = 0
+	n, err = db.Truncate(customers)
+	assertEqual(t, 0, n)
+	assertEqual(t, fmt.Sprintf("Customer %s has related Orders", cu.ID), err.Error())
+	assertEqual(t, 1, db.Count(customers))
+	assertEqual(t, 0, events)
+}
+
+func TestKeys(t *testing.T) {
+	openDB()
+	path := []string{"keys", "orders"}
+	for _, v := range []uint64{300, 2, 10} {
+		o := Order{Amount: int(v)}
+		o.ID = Uint64Key(v).String()
+		assertEqual(t, nil, db.Save(path, &o))
+	}
+	res := []Order{}
+	db.List(path, &res)
+	assertEqual(t, 3, len(res))
+	assertEqual(t, []int{2, 10, 300}, []int{res[0].Amount, res[1].Amount, res[2].Amount})
+	assertEqual(t, uint64(300), Key(res[2].ID).Uint64())
+
+	for _, k := range []Key{Uint64Key(200), Int64Key(5), TimeKey(time.Now())} {
+		o := Order{Amount: 1}
+		o.ID = k.String()
+		assertEqual(t, nil, db.Save(path, &o))
+		o1 := Order{}
+		assertEqual(t, nil, db.Find(path, k.String(), &o1))
+		assertEqual(t, k.String(), o1.ID)
+		n := db.Count(path)
+		o1.Amount = 2
+		assertEqual(t, nil, db.Save(path, &o1))
+		assertEqual(t, n, db.Count(path))
+		db.DeleteKeys(path, KeysStrings(k))
+	}
+	bad := Order{}
+	bad.ID = "\xff"
+	assertEqual(t, `invalid id "\xff": ids must be valid UTF-8`, db.Save(path, &bad).Error())
+
+	o := Order{}
+	assertEqual(t, nil, db.Find(path, Uint64Key(10).String(), &o))
+	assertEqual(t, 10, o.Amount)
+	res = []Order{}
+	assertEqual(t, nil, db.ListRange(path, Uint64Key(5).String(), Uint64Key(300).String(), &res))
+	assertEqual(t, 1, len(res))
+	res = []Order{}
+	db.ListKeys(path, KeysBytes(Uint64Key(2), Uint64Key(300)), &res)
+	assertEqual(t, 2, len(res))
+	db.DeleteKeys(path, KeysStrings(Uint64Key(2)))
+	assertEqual(t, 2, db.Count(path))
+
+	assertEqual(t, -1, bytes.Compare(Int64Key(-5), Int64Key(3)))
+	assertEqual(t, int64(-5), Int64Key(-5).Int64())
+	tm := time.Date(1960, 1, 2, 3, 4, 5, 6, time.UTC)
+	assertEqual(t, -1, bytes.Compare(TimeKey(tm), TimeKey(time.Now())))
+	assertEqual(t, tm, TimeKey(tm).Time())
+
+	k1 := TupleKey(StrKey("a"), Uint64Key(2))
+	k2 := TupleKey(StrKey("a\x00"), Uint64Key(1))
+	k3 := TupleKey(StrKey("b"), Uint64Key(0))
+	assertEqual(t, -1, bytes.Compare(k1, k2))
+	assertEqual(t, -1, bytes.Compare(k2, k3))
+	parts, err := k2.Tuple()
+	assertEqual(t, nil, err)
+	assertEqual(t, []Key{StrKey("a\x00"), Uint64Key(1)}, parts)
+	_, err = Key("a").Tuple()
+	assertEqual(t, "invalid tuple key", err.Error())
+	k4 := TupleKey(StrKey("a\x01"), Uint64Key(0))
+	assertEqual(t, -1, bytes.Compare(k2, k4))
+	assertEqual(t, -1, bytes.IndexByte(k4, 0))
+	parts, _ = k4.Tuple()
+	assertEqual(t, []Key{StrKey("a\x01"), Uint64Key(0)}, parts)
+
+	hpath := []string{"tuple_history"}
+	db.SetBucketOptions(hpath, BucketOptions{History: true})
+	for _, id := range []string{"a", TupleKey(StrKey("a"), StrKey("b")).String()} {
+		ho := Order{}
+		ho.ID = id
+		assertEqual(t, nil, db.Save(hpath, &ho))
+		assertEqual(t, nil, db.Save(hpath, &ho))
+	}
+	revs, err := db.History(hpath, "a")
+	assertEqual(t, nil, err)
+	assertEqual(t, 1, len(revs))
+	assertEqual(t, uint64(1), revs[0].Rev)
+	zero := Order{}
+	zero.ID = "a\x00b"
+	assertEqual(t, `invalid id "a\x00b": ids must not contain zero bytes`, db.Save(hpath, &zero).Error())
+}
+
+type Workspace struct {
+	Model
+	Name string
+}
+
+func (w *Workspace) BucketPath() Path {
+	return Path{"app", "workspaces"}
+}
+
+func TestPath(t *testing.T) {
+	openDB()
+	p := Path{"app", "a/b"}
+	assertEqual(t, `app/a\/b`, p.String())
+	p1, err := ParsePath(p.String())
+	assertEqual(t, nil, err)
+	assertEqual(t, p, p1)
+	assertEqual(t, Path{"app", "a/b", "c"}, p.Child("c"))
+	assertEqual(t, Path{"app"}, p.Parent())
+	assertEqual(t, Path(nil), p.Parent().Parent())
+	_, err = ParsePath("a//b")
+	assertEqual(t, "empty segment 1 of path a//b", err.Error())
+
+	a := Workspace{Name: "Main"}
+	assertEqual(t, nil, db.Save(nil, &a))
+	a1 := Workspace{}
+	assertEqual(t, nil, db.Find(nil, a.ID, &a1))
+	assertEqual(t, "Main", a1.Name)
+	res := []*Workspace{}
+	db.List(nil, &res)
+	assertEqual(t, 1, len(res))
+	assertEqual(t, 1, db.Count(Path{"app", "workspaces"}))
+	assertEqual(t, nil, db.Delete(nil, &a))
+
+	assertEqual(t, "Bucket app/users not found", db.Find(Path{"app", "users", "admins"}, "1", &a1).Error())
+	assertEqual(t, "No bucket provided", db.Find(nil, "1", &Person{}).Error())
+}
+
+func TestTenants(t *testing.T) {
+	openDB()
+	people := []string{"people"}
+	acme, globex := db.Tenant("acme"), db.Tenant("globex")
+
+	p := Person{Name: "John"}
+	assertEqual(t, nil, acme.Save(people, &p))
+	res := []Person{}
+	assertEqual(t, "Bucket people not found", globex.List(people, &res).Error())
+	p1 := Person{}
+	assertEqual(t, nil, db.Find([]string{"_tenants", "acme", "people"}, p.ID, &p1))
+	assertEqual(t, "John", p1.Name)
+
+	db.SetTenantQuota("globex", TenantQuota{MaxRecords: 2})
+	for i := 0; i < 2; i++ {
+		assertEqual(t, nil, globex.Save(people, &Person{Name: "Jane"}))
+	}
+	assertEqual(t, ErrQuotaExceeded, globex.Save(people, &Person{Name: "Jim"}))
+	globex.List(people, &res)
+	assertEqual(t, 2, len(res))
+	u, err := db.TenantUsage("globex")
+	assertEqual(t, nil, err)
+	assertEqual(t, int64(2), u.Records)
+	db.SetTenantQuota("globex", TenantQuota{MaxBytes: u.Bytes})
+	assertEqual(t, ErrQuotaExceeded, globex.SaveValue(people, "k", []byte("v")))
+
+	emit := func(id string, rec map[string]interface{}, emit func(string, interface{})) {
+		emit(fmt.Sprint(rec["Name"]), 1)
+	}
+	assertEqual(t, nil, acme.DefineView("names", people, emit))
+	rows, _ := acme.QueryView("names")
+	assertEqual(t, 1, len(rows))
+	_, err = globex.QueryView("names")
+	assertEqual(t, "View names not found", err.Error())
+
+	tenants, _ := db.Tenants()
+	assertEqual(t, []string{"acme", "globex"}, tenants)
+	var buf bytes.Buffer
+	assertEqual(t, nil, db.ExportTenant("acme", &buf))
+	exported := 0
+	for d := json.NewDecoder(&buf); ; exported++ {
+		r := ExportRecord{}
+		if d.Decode(&r) != nil {
+			break
+		}
+		if reflect.DeepEqual(r.Path, people) {
+			assertEqual(t, p.ID, string(r.Key))
+		}
+	}
+	assertEqual(t, true, exported > 1)
+
+	assertEqual(t, nil, db.DeleteTenant("acme"))
+	tenants, _ = db.Tenants()
+	assertEqual(t, []string{"globex"}, tenants)
+	u, _ = db.TenantUsage("acme")
+	assertEqual(t, TenantUsage{}, u)
+	assertEqual(t, "Change log can't be trimmed by tenant", globex.TrimChangeLog(1).Error())
+}
+
 var listFull bool
 
 func benchListPrepare() {
@@ -0,0 +1,143 @@
+package borm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// UpdateFields updates fields of stored record without loading it into model.
+// Values are encoded as they are stored by Save. Updated field is touched if
+// record has it and Updated event is emitted.
+// If Model is set in bucket options fields are named by Go field names and
+// updated record is validated by decoding into model, otherwise the event is
+// RecordUpdated with Record holding updated fields
+// 		db.UpdateFields([]string{"people"}, p.ID, map[string]interface{}{"Active": true})
+func (db *DB) UpdateFields(path []string, id string, fields map[string]interface{}) error {
+	path = db.scope(path)
+	l := logit(db.Log, "UPDATE-FIELDS", path, id, nil)
+	err := db.patchRecord(path, id, func(rec map[string]interface{}) error {
+		info := db.bucketOptions(path).typeInfo()
+		for k, v := range fields {
+			if info != nil {
+				k = info.storageName(k)
+			}
+			if v == nil {
+				rec[k] = nil
+				continue
+			}
+			enc, err := encode(v)
+			if err != nil {
+				return fmt.Errorf("could not encode %s: %s", k, err)
+			}
+			rec[k] = json.RawMessage(enc)
+		}
+		return nil
+	})
+	return l.done(err)
+}
+
+// Patch applies JSON merge patch (RFC 7386) to stored record and emits
+// Updated event as UpdateFields does. If Model is set in bucket options
+// patched record is validated by decoding into model
+// 		db.Patch([]string{"people"}, p.ID, []byte(`{"Name":"John","Age":null}`))
+func (db *DB) Patch(path []string, id string, patch []byte) error {
+	path = db.scope(path)
+	l := logit(db.Log, "PATCH", path, id, patch)
+	err := db.patchRecord(path, id, func(rec map[string]interface{}) error {
+		p, err := unmarshalMap(patch)
+		if err != nil {
+			return fmt.Errorf("invalid patch: %s", err)
+		}
+		mergePatch(rec, p)
+		return nil
+	})
+	return l.done(err)
+}
+
+func (db *DB) patchRecord(path []string, id string, apply func(map[string]interface{}) error) error {
+	if err := db.check(path); err != nil {
+		return err
+	}
+
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := getBucket(tx, path)
+		if b == nil {
+			return db.bucketNotFound(tx, path)
+		}
+		old, err := db.getRecord(b, path, id)
+		if err != nil {
+			return err
+		}
+		if old == nil {
+			return errors.New("Record not found")
+		}
+
+		rec, err := unmarshalMap(old)
+		if err != nil {
+			return err
+		}
+		prev, _ := unmarshalMap(old)
+
+		if err := apply(rec); err != nil {
+			return err
+		}
+		idName, updatedName := "ID", "Updated"
+		if info := db.bucketOptions(path).typeInfo(); info != nil {
+			idName, updatedName = info.storageName(idName), info.storageName(updatedName)
+		}
+		rec[idName] = prev[idName]
+		if _, ok := rec[updatedName]; ok {
+			rec[updatedName] = db.now()
+		}
+
+		enc, err := marshal(rec)
+		if err != nil {
+			return fmt.Errorf("could not encode %s: %s", id, err)
+		}
+		m := db.bucketOptions(path).newModel()
+		if m != nil {
+			if err := decode(enc, m); err != nil {
+				return fmt.Errorf("could not decode %s: %s", id, err)
+			}
+			if err := validateModel(m); err != nil {
+				return err
+			}
+		}
+		if err := db.putRecord(tx, b, path, "update", id, old, enc); err != nil {
+			return err
+		}
+
+		cur, _ := unmarshalMap(enc)
+		if m == nil {
+			r := &Record{Fields: cur}
+			r.setID(id)
+			addEvent("Updated", r, diffMaps(prev, cur, []string{idName, updatedName}))
+			return nil
+		}
+		addEvent("Updated", m, diffMaps(prev, cur, managedFields(m)))
+		return nil
+	})
+}
+
+// mergePatch applies merge patch p to target
+func mergePatch(target, p map[string]interface{}) {
+	for k, v := range p {
+		if v == nil {
+			delete(target, k)
+			continue
+		}
+		if pv, ok := v.(map[string]interface{}); ok {
+			tv, ok := target[k].(map[string]interface{})
+			if !ok {
+				tv = make(map[string]interface{})
+			}
+			mergePatch(tv, pv)
+			target[k] = tv
+			continue
+		}
+		target[k] = v
+	}
+}
@@ -0,0 +1,118 @@
+package borm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// Path is a path of nested buckets. Path can be used everywhere
+// []string path is expected
+// 		users := borm.Path{"app", "users"}
+// 		db.Save(users.Child("admins"), &u)
+type Path []string
+
+// BucketPather is implemented by models having default bucket path.
+// Save, Find, Delete, List, FindOrCreate and Upsert use it for nil paths
+// 		func (p *Person) BucketPath() borm.Path { return borm.Path{"people"} }
+// 		db.Save(nil, &p)
+type BucketPather interface {
+	BucketPath() Path
+}
+
+// ParsePath parses path of segments separated by slashes. Slashes and
+// backslashes in segments are escaped with backslash
+// 		p, err := borm.ParsePath(`app/users/a\/b`) // borm.Path{"app", "users", "a/b"}
+func ParsePath(s string) (Path, error) {
+	if s == "" {
+		return nil, errors.New("empty path")
+	}
+	var res Path
+	var seg []byte
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i++; i == len(s) {
+				return nil, fmt.Errorf("invalid escape at end of path %s", s)
+			}
+			seg = append(seg, s[i])
+		case '/':
+			res = append(res, string(seg))
+			seg = nil
+		default:
+			seg = append(seg, s[i])
+		}
+	}
+	res = append(res, string(seg))
+	for i, v := range res {
+		if v == "" {
+			return nil, fmt.Errorf("empty segment %d of path %s", i, s)
+		}
+	}
+	return res, nil
+}
+
+// Child returns path of nested bucket
+func (p Path) Child(names ...string) Path {
+	res := make(Path, len(p), len(p)+len(names))
+	copy(res, p)
+	return append(res, names...)
+}
+
+// Parent returns path of parent bucket or nil for root bucket
+func (p Path) Parent() Path {
+	if len(p) < 2 {
+		return nil
+	}
+	return append(Path{}, p[:len(p)-1]...)
+}
+
+// String returns path with segments separated by slashes
+func (p Path) String() string {
+	r := strings.NewReplacer(`\`, `\\`, `/`, `\/`)
+	segs := make([]string, len(p))
+	for i, v := range p {
+		segs[i] = r.Replace(v)
+	}
+	return strings.Join(segs, "/")
+}
+
+// modelPath returns BucketPath of model or of element of models slice
+// if path is empty
+func modelPath(path []string, i interface{}) []string {
+	if len(path) > 0 || i == nil {
+		return path
+	}
+	if p, ok := i.(BucketPather); ok {
+		return p.BucketPath()
+	}
+	t := reflect.TypeOf(i)
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Slice {
+		if p, ok := reflect.New(deref(t.Elem().Elem())).Interface().(BucketPather); ok {
+			return p.BucketPath()
+		}
+	}
+	return path
+}
+
+// bucketNotFound returns error naming the first missing segment of path.
+// Paths of tenant handles are named relative to tenant root bucket
+func (db *DB) bucketNotFound(tx *bolt.Tx, path []string) error {
+	n := 0
+	if len(path) > len(db.prefix) && pathKey(path[:len(db.prefix)]) == pathKey(db.prefix) {
+		n = len(db.prefix)
+	}
+	for i := range path {
+		if getBucket(tx, path[:i+1]) == nil {
+			end := i + 1
+			if end <= n {
+				end = n + 1
+			}
+			return fmt.Errorf("Bucket %s not found", Path(path[n:end]))
+		}
+	}
+	return fmt.Errorf("Bucket %s not found", Path(path[n:]))
+}
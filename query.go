@@ -0,0 +1,172 @@
+package borm
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Cond is a condition on stored field value used to filter records.
+// Supported operators are =, !=, <, <=, >, >=
+// 		borm.Cond{Field: "Status", Op: "=", Value: "paid"}
+type Cond struct {
+	Field string
+	Op    string
+	Value interface{}
+}
+
+// condFields returns names of fields used by conditions
+func condFields(conds []Cond) (res []string) {
+	for _, c := range conds {
+		res = appendUnique(res, c.Field)
+	}
+	return
+}
+
+// matchConds returns true if record rec matches all conditions
+func matchConds(rec map[string]interface{}, conds []Cond) (bool, error) {
+	for _, c := range conds {
+		ok, err := c.match(rec[c.Field])
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// match returns true if decoded field value v matches condition
+func (c Cond) match(v interface{}) (bool, error) {
+	n, err := compareValues(v, c.Value)
+	if err != nil {
+		return false, fmt.Errorf("%s: %s", c.Field, err)
+	}
+	switch c.Op {
+	case "=", "==":
+		return n == 0, nil
+	case "!=":
+		return n != 0, nil
+	case "<":
+		return n < 0, nil
+	case "<=":
+		return n <= 0, nil
+	case ">":
+		return n > 0, nil
+	case ">=":
+		return n >= 0, nil
+	}
+	return false, fmt.Errorf("unknown operator %s", c.Op)
+}
+
+// compareValues compares decoded field value v with condition value w.
+// Values of different kinds are not equal and ordered by their kinds
+func compareValues(v, w interface{}) (int, error) {
+	switch t := w.(type) {
+	case time.Time:
+		s, ok := v.(string)
+		if !ok {
+			return -1, nil
+		}
+		vt, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return -1, nil
+		}
+		switch {
+		case vt.Before(t):
+			return -1, nil
+		case vt.After(t):
+			return 1, nil
+		}
+		return 0, nil
+	case nil:
+		if v == nil {
+			return 0, nil
+		}
+		return 1, nil
+	}
+
+	if f, ok := toFloat(w); ok {
+		vf, ok := toFloat(v)
+		if !ok {
+			return -1, nil
+		}
+		switch {
+		case vf < f:
+			return -1, nil
+		case vf > f:
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	switch t := w.(type) {
+	case string:
+		s, ok := v.(string)
+		if !ok {
+			return -1, nil
+		}
+		switch {
+		case s < t:
+			return -1, nil
+		case s > t:
+			return 1, nil
+		}
+		return 0, nil
+	case bool:
+		b, ok := v.(bool)
+		switch {
+		case !ok || !b && t:
+			return -1, nil
+		case b && !t:
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("unsupported value %v", w)
+}
+
+// toFloat returns float value of decoded or Go number
+func toFloat(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case json.Number:
+		f, err := t.Float64()
+		return f, err == nil
+	case float64:
+		return t, true
+	case float32:
+		return float64(t), true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	}
+	return 0, false
+}
+
+// decodeFields decodes fields of record enc. All fields are decoded if
+// fields is empty
+func decodeFields(enc []byte, fields []string) (map[string]interface{}, error) {
+	if len(fields) == 0 {
+		return unmarshalMap(enc)
+	}
+	var raw map[string]json.RawMessage
+	if err := unmarshal(enc, &raw); err != nil {
+		return nil, err
+	}
+	res := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		v, ok := raw[f]
+		if !ok {
+			continue
+		}
+		var i interface{}
+		if err := unmarshalValue(v, &i); err != nil {
+			return nil, err
+		}
+		res[f] = i
+	}
+	return res, nil
+}
@@ -0,0 +1,315 @@
+package borm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// relation kinds
+const (
+	relHasMany   = "hasMany"
+	relBelongsTo = "belongsTo"
+)
+
+// Actions applied to related records on Delete
+const (
+	OnDeleteRestrict = "restrict"
+	OnDeleteCascade  = "cascade"
+	OnDeleteNullify  = "nullify"
+)
+
+// relID is a segment of related bucket path replaced with id of record
+const relID = "{id}"
+
+// relation describes relation defined with struct tag. Related bucket is
+// a sibling of bucket of record unless it's a path from root bucket.
+// Segment {id} of path is replaced with id of record, all records of such
+// nested bucket are related to the record
+// 		Orders []Order `borm:"hasMany:orders,fk=UserID,onDelete=cascade"`
+// 		Orders []Order `borm:"hasMany:users/{id}/orders"`
+// 		User   *User   `borm:"belongsTo:users"`
+type relation struct {
+	field    string
+	index    []int
+	kind     string
+	bucket   string
+	fk       string
+	onDelete string
+	// path is path of related bucket set in tag
+	path Path
+	// nested is true if path has {id} segment
+	nested bool
+	// loaded is true if field holds related records
+	loaded bool
+	elem   reflect.Type
+}
+
+func parseRelation(t reflect.Type, f reflect.StructField, opts tagOptions) *relation {
+	r := &relation{field: f.Name, index: f.Index, onDelete: opts["onDelete"]}
+	switch {
+	case opts.Has(relHasMany):
+		r.kind, r.bucket, r.fk = relHasMany, opts[relHasMany], opts["fk"]
+		if r.fk == "" {
+			r.fk = t.Name() + "ID"
+		}
+		r.elem = deref(deref(f.Type).Elem())
+		r.loaded = true
+	case opts.Has(relBelongsTo):
+		r.kind, r.bucket, r.fk = relBelongsTo, opts[relBelongsTo], opts["fk"]
+		r.elem = deref(f.Type)
+		r.loaded = r.elem.Kind() == reflect.Struct
+		if r.fk == "" {
+			r.fk = f.Name
+			if r.loaded {
+				r.fk += "ID"
+			}
+		}
+	default:
+		return nil
+	}
+	if strings.Contains(r.bucket, "/") {
+		if p, err := ParsePath(r.bucket); err == nil {
+			r.path, r.nested = p, contains(p, relID)
+		}
+	}
+	return r
+}
+
+// relatedPath returns path of bucket of records related by r to record id
+// stored in bucket path
+func (db *DB) relatedPath(r *relation, path []string, id string) []string {
+	if r.path == nil {
+		return siblingPath(path, r.bucket)
+	}
+	res := make([]string, len(r.path))
+	for i, s := range r.path {
+		if s == relID {
+			s = id
+		}
+		res[i] = s
+	}
+	return db.scope(res)
+}
+
+// relatedIDs returns ids of records of bucket rpath related by hasMany
+// relation r to record id
+func (db *DB) relatedIDs(tx *bolt.Tx, r *relation, rpath []string, id string) ([]string, error) {
+	if !r.nested {
+		return db.findBy(tx, rpath, r.fkName(), id)
+	}
+	b := getBucket(tx, rpath)
+	if b == nil {
+		return nil, nil
+	}
+	var ids []string
+	err := b.ForEach(func(k, v []byte) error {
+		if v != nil {
+			ids = append(ids, string(k))
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// fkName returns storage name of foreign key of hasMany relation
+func (r *relation) fkName() string {
+	return getTypeInfo(r.elem).storageName(r.fk)
+}
+
+// Preload returns database handle that loads relations fields on Find, List
+// and ListKeys
+// 		u := User{}
+// 		db.Preload("Orders").Find([]string{"users"}, id, &u)
+func (db *DB) Preload(fields ...string) *DB {
+	d := *db
+	d.preloads = append(append([]string{}, db.preloads...), fields...)
+	return &d
+}
+
+// preload loads relations requested with Preload into model i
+func (db *DB) preload(tx *bolt.Tx, path []string, i interface{}) error {
+	if len(db.preloads) == 0 {
+		return nil
+	}
+	v := reflect.Indirect(reflect.ValueOf(i))
+	info := getTypeInfo(v.Type())
+	for _, name := range db.preloads {
+		r := info.relation(name)
+		if r == nil || !r.loaded {
+			return fmt.Errorf("unknown relation %s", name)
+		}
+		if err := db.loadRelation(tx, r, path, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadRelation fills relation field r of model v
+func (db *DB) loadRelation(tx *bolt.Tx, r *relation, path []string, v reflect.Value) error {
+	f := v.FieldByIndex(r.index)
+
+	if r.kind == relBelongsTo {
+		fk := v.FieldByName(r.fk)
+		if !fk.IsValid() {
+			return fmt.Errorf("unknown field %s", r.fk)
+		}
+		id := fmt.Sprint(fk.Interface())
+		rpath := db.relatedPath(r, path, id)
+		b := getBucket(tx, rpath)
+		if b == nil || id == "" {
+			return nil
+		}
+		data, err := db.getRecord(b, rpath, id)
+		if err != nil || data == nil {
+			return err
+		}
+		item := reflect.New(r.elem)
+		if err := decode(data, item.Interface()); err != nil {
+			return err
+		}
+		setValue(f, item)
+		return nil
+	}
+
+	m, ok := v.Addr().Interface().(mod)
+	if !ok {
+		return fmt.Errorf("%s is not a model", v.Type().Name())
+	}
+	rpath := db.relatedPath(r, path, m.GetID())
+	ids, err := db.relatedIDs(tx, r, rpath, m.GetID())
+	if err != nil {
+		return err
+	}
+	b := getBucket(tx, rpath)
+	res := reflect.MakeSlice(f.Type(), 0, len(ids))
+	for _, id := range ids {
+		data, err := db.getRecord(b, rpath, id)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			continue
+		}
+		item := reflect.New(r.elem)
+		if err := decode(data, item.Interface()); err != nil {
+			return err
+		}
+		if f.Type().Elem().Kind() == reflect.Ptr {
+			res = reflect.Append(res, item)
+		} else {
+			res = reflect.Append(res, item.Elem())
+		}
+	}
+	f.Set(res)
+	return nil
+}
+
+// deleteModel deletes record of type t by id applying onDelete actions of
+// its relations
+func (db *DB) deleteModel(tx *bolt.Tx, path []string, id string, t reflect.Type) error {
+	b := getBucket(tx, path)
+	if b == nil {
+		return db.bucketNotFound(tx, path)
+	}
+	if b.Get([]byte(id)) == nil {
+		return nil
+	}
+
+	for _, r := range getTypeInfo(t).relations {
+		if r.kind != relHasMany || r.onDelete == "" {
+			continue
+		}
+		rpath := db.relatedPath(r, path, id)
+		ids, err := db.relatedIDs(tx, r, rpath, id)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		switch r.onDelete {
+		case OnDeleteRestrict:
+			return fmt.Errorf("%s %s has related %s", deref(t).Name(), id, r.field)
+		case OnDeleteCascade:
+			for _, cid := range ids {
+				if err := db.cascadeDelete(tx, rpath, cid, r.elem); err != nil {
+					return err
+				}
+			}
+		case OnDeleteNullify:
+			if r.nested {
+				return fmt.Errorf("onDelete %s can't be used with nested relation %s", r.onDelete, r.field)
+			}
+			for _, cid := range ids {
+				if err := db.nullify(tx, rpath, cid, r.fkName()); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("unknown onDelete action %s", r.onDelete)
+		}
+	}
+
+	old, err := db.getRecord(b, path, id)
+	if err != nil {
+		return err
+	}
+	return db.deleteRecord(tx, b, path, id, old)
+}
+
+func (db *DB) cascadeDelete(tx *bolt.Tx, path []string, id string, t reflect.Type) error {
+	data, err := db.getRecord(getBucket(tx, path), path, id)
+	if err != nil || data == nil {
+		return err
+	}
+	m, ok := reflect.New(t).Interface().(mod)
+	if !ok {
+		return fmt.Errorf("%s is not a model", t.Name())
+	}
+	if err := decode(data, m); err != nil {
+		return err
+	}
+	if err := db.deleteModel(tx, path, id, t); err != nil {
+		return err
+	}
+	addEvent("Deleted", m)
+	return nil
+}
+
+func (db *DB) nullify(tx *bolt.Tx, path []string, id string, fk string) error {
+	b := getBucket(tx, path)
+	old, err := db.getRecord(b, path, id)
+	if err != nil {
+		return err
+	}
+	rec, err := unmarshalMap(old)
+	if err != nil {
+		return err
+	}
+	rec[fk] = ""
+	enc, err := marshal(rec)
+	if err != nil {
+		return err
+	}
+	return db.putRecord(tx, b, path, "update", id, old, enc)
+}
+
+// siblingPath returns path of bucket name located next to bucket path
+func siblingPath(path []string, name string) []string {
+	res := make([]string, len(path))
+	copy(res, path)
+	res[len(res)-1] = name
+	return res
+}
+
+func setValue(f reflect.Value, item reflect.Value) {
+	if f.Kind() == reflect.Ptr {
+		f.Set(item)
+	} else {
+		f.Set(item.Elem())
+	}
+}
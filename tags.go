@@ -0,0 +1,219 @@
+package borm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// tagOptions is a parsed borm struct tag.
+// 		`borm:"hasMany:orders,fk=UserID"` // {"hasMany": "orders", "fk": "UserID"}
+type tagOptions map[string]string
+
+func parseTag(tag string) tagOptions {
+	res := make(tagOptions)
+	if tag == "" {
+		return res
+	}
+	for _, v := range strings.Split(tag, ",") {
+		v = strings.TrimSpace(v)
+		if i := strings.IndexAny(v, ":="); i >= 0 {
+			res[v[:i]] = v[i+1:]
+		} else if v != "" {
+			res[v] = ""
+		}
+	}
+	return res
+}
+
+// Has returns true if option is present in tag
+func (t tagOptions) Has(name string) bool {
+	_, ok := t[name]
+	return ok
+}
+
+// tagFlags are borm tag options that are not field names
+var tagFlags = map[string]bool{
+	"-":         true,
+	"omitempty": true,
+	"encrypt":   true,
+	"index":     true,
+	"fulltext":  true,
+	"geo":       true,
+}
+
+// Name returns storage name set in tag
+// 		`borm:"name,omitempty"` // "name"
+func (t tagOptions) Name() string {
+	for k, v := range t {
+		if v == "" && !tagFlags[k] {
+			return k
+		}
+	}
+	return ""
+}
+
+// typeInfo is a model type description built from struct tags
+type typeInfo struct {
+	relations []*relation
+	// indexes lists fields that have to be indexed
+	indexes []string
+	// encrypted lists fields that have to be encrypted
+	encrypted []string
+	// fulltext lists fields that have to be indexed for full-text search
+	fulltext []string
+	// geo lists location fields that have to be indexed for geo queries
+	geo []string
+	// fields lists fields stored in database
+	fields []*storageField
+	// err is set if tags of type can't be used together
+	err error
+}
+
+// storageField describes struct field stored in database
+type storageField struct {
+	name      string
+	goName    string
+	index     []int
+	omitEmpty bool
+}
+
+var typeInfos = struct {
+	sync.RWMutex
+	m map[reflect.Type]*typeInfo
+}{m: make(map[reflect.Type]*typeInfo)}
+
+// getTypeInfo returns cached description of struct type t
+func getTypeInfo(t reflect.Type) *typeInfo {
+	t = deref(t)
+	typeInfos.RLock()
+	info, ok := typeInfos.m[t]
+	typeInfos.RUnlock()
+	if ok {
+		return info
+	}
+
+	info = &typeInfo{}
+	if t.Kind() == reflect.Struct {
+		for _, f := range reflect.VisibleFields(t) {
+			if f.PkgPath != "" || f.Anonymous && deref(f.Type).Kind() == reflect.Struct {
+				continue
+			}
+			opts := parseTag(f.Tag.Get("borm"))
+			r := parseRelation(t, f, opts)
+			if r != nil {
+				info.relations = append(info.relations, r)
+			}
+			if opts.Has("-") || r != nil && r.loaded {
+				continue
+			}
+			if sf := newStorageField(f, opts); sf != nil {
+				info.fields = append(info.fields, sf)
+				if opts.Has("index") {
+					info.indexes = appendUnique(info.indexes, sf.name)
+				}
+				if opts.Has("encrypt") {
+					info.encrypted = append(info.encrypted, sf.name)
+				}
+				if opts.Has("fulltext") {
+					info.fulltext = append(info.fulltext, sf.name)
+				}
+				if opts.Has("geo") {
+					info.geo = append(info.geo, sf.name)
+				}
+			}
+		}
+		for _, r := range info.relations {
+			if r.kind == relBelongsTo {
+				info.indexes = appendUnique(info.indexes, info.storageName(r.fk))
+			}
+		}
+		for _, f := range info.encrypted {
+			if contains(info.indexes, f) || contains(info.fulltext, f) || contains(info.geo, f) {
+				info.err = fmt.Errorf("%s.%s: encrypted field can't be indexed", t.Name(), f)
+				break
+			}
+		}
+	}
+
+	typeInfos.Lock()
+	typeInfos.m[t] = info
+	typeInfos.Unlock()
+	return info
+}
+
+// newStorageField returns description of stored field or nil if field is
+// not stored. Fields having borm tag are stored by its options, other fields
+// are stored by their json tag options for compatibility
+func newStorageField(f reflect.StructField, opts tagOptions) *storageField {
+	sf := &storageField{name: f.Name, goName: f.Name, index: f.Index}
+	if _, ok := f.Tag.Lookup("borm"); ok {
+		if n := opts.Name(); n != "" {
+			sf.name = n
+		}
+		sf.omitEmpty = opts.Has("omitempty")
+		return sf
+	}
+
+	json := strings.Split(f.Tag.Get("json"), ",")
+	if json[0] == "-" && len(json) == 1 {
+		return nil
+	}
+	if json[0] != "" {
+		sf.name = json[0]
+	}
+	sf.omitEmpty = contains(json[1:], "omitempty")
+	return sf
+}
+
+// field returns stored field by storage name
+func (t *typeInfo) field(name string) *storageField {
+	for _, f := range t.fields {
+		if f.name == name {
+			return f
+		}
+	}
+	for _, f := range t.fields {
+		if strings.EqualFold(f.name, name) {
+			return f
+		}
+	}
+	return nil
+}
+
+// storageName returns storage name of field with Go name goName
+func (t *typeInfo) storageName(goName string) string {
+	for _, f := range t.fields {
+		if f.goName == goName {
+			return f.name
+		}
+	}
+	return goName
+}
+
+// relation returns relation defined on field name
+func (t *typeInfo) relation(name string) *relation {
+	for _, r := range t.relations {
+		if r.field == name {
+			return r
+		}
+	}
+	return nil
+}
+
+func contains(s []string, v string) bool {
+	for _, i := range s {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(s []string, v string) []string {
+	if contains(s, v) {
+		return s
+	}
+	return append(s, v)
+}
@@ -0,0 +1,233 @@
+package borm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/boltdb/bolt"
+)
+
+// tenantsBucket is the root bucket holding buckets of tenants
+const tenantsBucket = "_tenants"
+
+// ErrQuotaExceeded is returned by writes exceeding tenant quota
+var ErrQuotaExceeded = errors.New("Tenant quota exceeded")
+
+// TenantQuota limits number of records and their stored size of tenant.
+// Zero values mean no limit
+type TenantQuota struct {
+	MaxRecords int64
+	MaxBytes   int64
+}
+
+// TenantUsage is number of records and their stored size of tenant
+type TenantUsage struct {
+	Records int64
+	Bytes   int64
+}
+
+// ExportRecord is a stored value of tenant exported by ExportTenant.
+// Path is relative to tenant root bucket
+type ExportRecord struct {
+	Path  []string
+	Key   []byte
+	Value []byte
+}
+
+// Tenant returns handle with all bucket paths placed into root bucket of
+// tenant id. Tenant handles can't reach buckets outside of their tenant.
+// Tenants of tenant handle are nested into its tenant
+// 		t := db.Tenant("acme")
+// 		t.Save([]string{"people"}, &p) // saved into _tenants/acme/people
+func (db *DB) Tenant(id string) *DB {
+	d := *db
+	d.prefix = append(db.scope([]string{tenantsBucket}), id)
+	return &d
+}
+
+// scope returns path placed into tenant root bucket of tenant handle
+func (db *DB) scope(path []string) []string {
+	if len(db.prefix) == 0 || len(path) == 0 {
+		return path
+	}
+	return append(append(make([]string, 0, len(db.prefix)+len(path)), db.prefix...), path...)
+}
+
+// Tenants returns ids of tenants having buckets
+func (db *DB) Tenants() ([]string, error) {
+	path := db.scope([]string{tenantsBucket})
+	l := logit(db.Log, "TENANTS", path, "", nil)
+	res, err := db.tenants(path)
+	return res, l.done(err)
+}
+
+func (db *DB) tenants(path []string) (res []string, err error) {
+	if err = db.check(path); err != nil {
+		return
+	}
+
+	err = db.db.View(func(tx *bolt.Tx) error {
+		b := getBucket(tx, path)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				res = append(res, string(k))
+			}
+			return nil
+		})
+	})
+	return
+}
+
+// SetTenantQuota sets quota of tenant id checked on writes. Usage is summed
+// from record counters of tenant buckets which are created on first write into
+// bucket, so buckets not written since counters were introduced are not
+// counted. Indexes, views and history are not counted
+// 		db.SetTenantQuota("acme", borm.TenantQuota{MaxRecords: 1000, MaxBytes: 1 << 20})
+func (db *DB) SetTenantQuota(id string, q TenantQuota) {
+	if db.buckets == nil {
+		db.buckets = newBucketRegistry()
+	}
+	db.buckets.mu.Lock()
+	db.buckets.quotas[pathKey(db.Tenant(id).prefix)] = q
+	db.buckets.mu.Unlock()
+}
+
+// GetTenantQuota returns quota of tenant id
+func (db *DB) GetTenantQuota(id string) TenantQuota {
+	return db.tenantQuota(db.Tenant(id).prefix)
+}
+
+func (db *DB) tenantQuota(prefix []string) TenantQuota {
+	if db.buckets == nil {
+		return TenantQuota{}
+	}
+	db.buckets.mu.RLock()
+	defer db.buckets.mu.RUnlock()
+	return db.buckets.quotas[pathKey(prefix)]
+}
+
+// TenantUsage returns number of records and their stored size of tenant id.
+// Only buckets having record counters are counted as described in SetTenantQuota
+func (db *DB) TenantUsage(id string) (TenantUsage, error) {
+	prefix := db.Tenant(id).prefix
+	l := logit(db.Log, "TENANT-USAGE", prefix, id, nil)
+	var res TenantUsage
+	err := db.check(prefix)
+	if err == nil {
+		err = db.db.View(func(tx *bolt.Tx) error {
+			res = tenantUsage(tx, prefix)
+			return nil
+		})
+	}
+	return res, l.done(err)
+}
+
+// tenantUsage sums usage of counted buckets of tenant with root bucket prefix
+func tenantUsage(tx *bolt.Tx, prefix []string) (res TenantUsage) {
+	cb := getBucket(tx, []string{metaBucket, countsBucket})
+	if cb == nil {
+		return
+	}
+	p := []byte(pathKey(prefix) + "\x00")
+	c := cb.Cursor()
+	for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+		if len(v) == 16 {
+			res.Records += int64(btoi(v[:8]))
+			res.Bytes += int64(btoi(v[8:]))
+		}
+	}
+	return
+}
+
+// checkQuota returns ErrQuotaExceeded if adding delta records of size bytes
+// into bucket path exceeds quota of its tenant. Quotas of all tenants path
+// is nested into are checked
+func (db *DB) checkQuota(tx *bolt.Tx, path []string, delta, size int64) error {
+	for i := 0; i+1 < len(path); i += 2 {
+		if path[i] != tenantsBucket {
+			return nil
+		}
+		prefix := path[:i+2]
+		q := db.tenantQuota(prefix)
+		if q.MaxRecords == 0 && q.MaxBytes == 0 {
+			continue
+		}
+		u := tenantUsage(tx, prefix)
+		if q.MaxRecords > 0 && delta > 0 && u.Records+delta > q.MaxRecords ||
+			q.MaxBytes > 0 && size > 0 && u.Bytes+size > q.MaxBytes {
+			return ErrQuotaExceeded
+		}
+	}
+	return nil
+}
+
+// ExportTenant writes all values stored by tenant id as JSON encoded
+// ExportRecord per line. Values are exported as stored
+// 		f, _ := os.Create("acme.jsonl")
+// 		db.ExportTenant("acme", f)
+func (db *DB) ExportTenant(id string, w io.Writer) error {
+	prefix := db.Tenant(id).prefix
+	l := logit(db.Log, "EXPORT-TENANT", prefix, id, nil)
+	err := db.exportTenant(prefix, w)
+	return l.done(err)
+}
+
+func (db *DB) exportTenant(prefix []string, w io.Writer) error {
+	if err := db.check(prefix); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	return db.db.View(func(tx *bolt.Tx) error {
+		b := getBucket(tx, prefix)
+		if b == nil {
+			return db.bucketNotFound(tx, prefix)
+		}
+		return exportBucket(b, nil, enc)
+	})
+}
+
+func exportBucket(b *bolt.Bucket, path []string, enc *json.Encoder) error {
+	return b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return exportBucket(b.Bucket(k), append(path[:len(path):len(path)], string(k)), enc)
+		}
+		return enc.Encode(ExportRecord{Path: path, Key: k, Value: v})
+	})
+}
+
+// DeleteTenant deletes all buckets of tenant id and their metadata
+// 		db.DeleteTenant("acme")
+func (db *DB) DeleteTenant(id string) error {
+	prefix := db.Tenant(id).prefix
+	l := logit(db.Log, "DELETE-TENANT", prefix, id, nil)
+	err := db.deleteTenant(prefix)
+	return l.done(err)
+}
+
+func (db *DB) deleteTenant(prefix []string) error {
+	if err := db.check(prefix); err != nil {
+		return err
+	}
+
+	return db.db.Update(func(tx *bolt.Tx) error {
+		root := getBucket(tx, prefix[:len(prefix)-1])
+		id := prefix[len(prefix)-1]
+		if root == nil || root.Bucket([]byte(id)) == nil {
+			return db.bucketNotFound(tx, prefix)
+		}
+		if err := root.DeleteBucket([]byte(id)); err != nil {
+			return fmt.Errorf("delete bucket: %s", err)
+		}
+		if err := dropMeta(tx, prefix); err != nil {
+			return err
+		}
+		return db.logChange(tx, OpDeleteBucket, prefix[:len(prefix)-1], id, nil)
+	})
+}
@@ -0,0 +1,215 @@
+package borm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// rollupSuffix is appended to bucket name to get rollup bucket name
+const rollupSuffix = "_rollup"
+
+// RollupSpec describes downsampling of numeric field of time-series bucket
+// into intervals
+type RollupSpec struct {
+	Field    string
+	Interval time.Duration
+}
+
+// Rollup is aggregated value of field in interval starting at Start
+type Rollup struct {
+	Start time.Time
+	Count int64
+	Min   float64
+	Max   float64
+	Sum   float64
+	Avg   float64
+}
+
+// Append stores value of time-series bucket at time t. Records of
+// time-series buckets are keyed by TimeKey of their timestamps so they are
+// ordered by time. Records older than bucket retention are dropped and
+// rollups are updated
+// 		db.SetBucketOptions([]string{"cpu"}, borm.BucketOptions{
+// 			Retention: 24 * time.Hour,
+// 			Rollups:   []borm.RollupSpec{{Field: "Value", Interval: time.Hour}},
+// 		})
+// 		db.Append([]string{"cpu"}, time.Now(), Sample{Value: 0.5})
+func (db *DB) Append(path []string, t time.Time, val interface{}) error {
+	path = db.scope(path)
+	l := logit(db.Log, "APPEND", path, t.String(), val)
+	err := db.append(path, t, val)
+	return l.done(err)
+}
+
+func (db *DB) append(path []string, t time.Time, val interface{}) error {
+	if err := db.check(path); err != nil {
+		return err
+	}
+	enc, err := encode(val)
+	if err != nil {
+		return fmt.Errorf("could not encode value: %s", err)
+	}
+
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b, err := createBucket(tx, path)
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		ts := t.UnixNano()
+		for b.Get(Int64Key(ts).Bytes()) != nil {
+			ts++
+		}
+		if err := db.putRecord(tx, b, path, "update", Int64Key(ts).String(), nil, enc); err != nil {
+			return err
+		}
+
+		o := db.bucketOptions(path)
+		for _, r := range o.Rollups {
+			if err := db.rollup(tx, path, r, t, enc); err != nil {
+				return err
+			}
+		}
+		if o.Retention > 0 {
+			return db.dropBefore(tx, b, path, db.now().Add(-o.Retention))
+		}
+		return nil
+	})
+}
+
+// Range fills models slice with records of time-series bucket stored
+// from time from up to time to excluding it
+// 		res := []Sample{}
+// 		db.Range([]string{"cpu"}, time.Now().Add(-time.Hour), time.Now(), &res)
+func (db *DB) Range(path []string, from, to time.Time, dest interface{}) error {
+	path = db.scope(path)
+	l := logit(db.Log, "RANGE", path, "", []time.Time{from, to})
+	err := db.timeRange(path, from, to, dest)
+	return l.done(err)
+}
+
+func (db *DB) timeRange(path []string, from, to time.Time, dest interface{}) error {
+	if err := db.check(path); err != nil {
+		return err
+	}
+
+	var keys [][]byte
+	err := db.db.View(func(tx *bolt.Tx) error {
+		b := getBucket(tx, path)
+		if b == nil {
+			return db.bucketNotFound(tx, path)
+		}
+		max := TimeKey(to).Bytes()
+		c := b.Cursor()
+		for k, _ := c.Seek(TimeKey(from).Bytes()); k != nil && bytes.Compare(k, max) < 0; k, _ = c.Next() {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return db.listKeys(path, keys, dest)
+}
+
+// ApplyRetention drops records of time-series bucket older than its retention
+func (db *DB) ApplyRetention(path []string) error {
+	path = db.scope(path)
+	l := logit(db.Log, "RETENTION", path, "", nil)
+	err := db.applyRetention(path)
+	return l.done(err)
+}
+
+func (db *DB) applyRetention(path []string) error {
+	if err := db.check(path); err != nil {
+		return err
+	}
+	o := db.bucketOptions(path)
+	if o.Retention <= 0 {
+		return nil
+	}
+
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := getBucket(tx, path)
+		if b == nil {
+			return db.bucketNotFound(tx, path)
+		}
+		return db.dropBefore(tx, b, path, db.now().Add(-o.Retention))
+	})
+}
+
+// dropBefore deletes records of time-series bucket stored before t
+func (db *DB) dropBefore(tx *bolt.Tx, b *bolt.Bucket, path []string, t time.Time) error {
+	limit := TimeKey(t).Bytes()
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil && bytes.Compare(k, limit) < 0; k, _ = c.First() {
+		old, err := db.getRecord(b, path, string(k))
+		if err != nil {
+			return err
+		}
+		if err := db.deleteRecord(tx, b, path, string(k), old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RollupPath returns path of bucket holding rollups of field
+// 		res := []borm.Rollup{}
+// 		db.Range(borm.RollupPath([]string{"cpu"}, "Value", time.Hour), from, to, &res)
+func RollupPath(path []string, field string, interval time.Duration) []string {
+	return siblingPath(path, fmt.Sprintf("%s%s_%s_%s", path[len(path)-1], rollupSuffix, field, interval))
+}
+
+// rollup adds field value of record enc stored at time t into rollup r
+func (db *DB) rollup(tx *bolt.Tx, path []string, r RollupSpec, t time.Time, enc []byte) error {
+	rec, err := unmarshalMap(enc)
+	if err != nil {
+		return err
+	}
+	n, ok := rec[r.Field].(json.Number)
+	if !ok {
+		return nil
+	}
+	v, err := n.Float64()
+	if err != nil {
+		return nil
+	}
+
+	rpath := RollupPath(path, r.Field, r.Interval)
+	b, err := createBucket(tx, rpath)
+	if err != nil {
+		return fmt.Errorf("create rollup bucket: %s", err)
+	}
+	start := t.Truncate(r.Interval).UTC()
+	key := TimeKey(start).String()
+
+	old, err := db.getRecord(b, rpath, key)
+	if err != nil {
+		return err
+	}
+	agg := Rollup{Start: start, Min: v, Max: v}
+	if old != nil {
+		if err := unmarshal(old, &agg); err != nil {
+			return err
+		}
+	}
+	agg.Count++
+	agg.Sum += v
+	if v < agg.Min {
+		agg.Min = v
+	}
+	if v > agg.Max {
+		agg.Max = v
+	}
+	agg.Avg = agg.Sum / float64(agg.Count)
+
+	val, err := marshal(agg)
+	if err != nil {
+		return err
+	}
+	return db.putRecord(tx, b, rpath, "update", key, old, val)
+}
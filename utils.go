@@ -1,6 +1,8 @@
 package borm
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"reflect"
@@ -48,6 +50,26 @@ func unmarshal(data []byte, i interface{}) error {
 	return nil
 }
 
+// unmarshalMap decodes record into map keeping numbers precision.
+// empty data returns empty map
+func unmarshalMap(data []byte) (map[string]interface{}, error) {
+	res := make(map[string]interface{})
+	if len(data) == 0 {
+		return res, nil
+	}
+	if err := unmarshalValue(data, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// unmarshalValue decodes data keeping numbers as json.Number
+func unmarshalValue(data []byte, i interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	return d.Decode(i)
+}
+
 func marshal(i interface{}) ([]byte, error) {
 	enc, err := json.Marshal(i)
 	if err != nil {
@@ -61,6 +83,31 @@ func nextID(b *bolt.Bucket) string {
 	return fmt.Sprint(id)
 }
 
+// itob returns 8-byte big endian representation of v
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// btoi returns uint64 from 8-byte big endian representation
+func btoi(b []byte) uint64 {
+	return binary.BigEndian.Uint64(b)
+}
+
+// hasPrefix returns true if path starts with all prefix segments
+func hasPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i, v := range prefix {
+		if path[i] != v {
+			return false
+		}
+	}
+	return true
+}
+
 // deref is Indirect for reflect.Types
 func deref(t reflect.Type) reflect.Type {
 	if t.Kind() == reflect.Ptr {
@@ -77,22 +124,15 @@ func baseType(t reflect.Type, expected reflect.Kind) (reflect.Type, error) {
 	return t, nil
 }
 
-func checkID(m mod) (id string, newItem bool) {
+// checkID generates id of model without id and sets its update time
+func checkID(m mod, now time.Time) (id string) {
 	id = m.GetID()
 	if id == "" {
-		newItem = true
 		id = fmt.Sprint(time.Now().UnixNano())
 		m.setID(id)
-		// if m1, ok := m.(modCreate); ok {
-		// 	m1.setCreation()
-		// }
-		if m1, ok := m.(modUpdate); ok {
-			m1.touchModel()
-		}
-	} else {
-		if m1, ok := m.(modUpdate); ok {
-			m1.touchModel()
-		}
+	}
+	if m1, ok := m.(modUpdate); ok {
+		m1.touchModel(now)
 	}
 	return
 }
@@ -101,8 +141,8 @@ func typeName(i interface{}) string {
 	return reflect.TypeOf(i).Elem().Name()
 }
 
-var addEvent = func(name string, m mod) {
-	go Events.Pub(eventName(name, m), m)
+var addEvent = func(name string, m mod, objs ...interface{}) {
+	go Events.Pub(eventName(name, m), append([]interface{}{m}, objs...)...)
 }
 
 func eventName(name string, m mod) string {
@@ -0,0 +1,223 @@
+package borm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalid is returned by Save for models failed validation
+var ErrInvalid = errors.New("model is not valid")
+
+type validatable interface {
+	getValidator() *validator
+}
+
+// formats available in format validation rule
+var formats = map[string]string{
+	"email": emailFormat,
+	"uuid":  uuidFormat,
+}
+
+// Validate validates model fields with rules from validate struct tags and
+// adds errors into model. Nested structs and slices of structs are validated
+// with field names prefixed by parent field name.
+// Supported rules: required, min=N, max=N, format=email|url|uuid|regex, oneof=a|b
+// 		type Person struct {
+// 			borm.Model
+// 			Name  string `validate:"required,min=3,max=50"`
+// 			Email string `validate:"format=email"`
+// 		}
+// 		ok := borm.Validate(&p)
+func Validate(m validatable) bool {
+	v := m.getValidator()
+	validateStruct(v, "", reflect.Indirect(reflect.ValueOf(m)))
+	return v.Valid()
+}
+
+var validations = struct {
+	sync.RWMutex
+	m map[reflect.Type]bool
+}{m: make(map[reflect.Type]bool)}
+
+// hasValidation returns true if type t or its nested types have validate
+// struct tags
+func hasValidation(t reflect.Type) bool {
+	t = deref(t)
+	validations.RLock()
+	res, ok := validations.m[t]
+	validations.RUnlock()
+	if ok {
+		return res
+	}
+
+	res = findValidation(t, make(map[reflect.Type]bool))
+	validations.Lock()
+	validations.m[t] = res
+	validations.Unlock()
+	return res
+}
+
+func findValidation(t reflect.Type, seen map[reflect.Type]bool) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return false
+	}
+	seen[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if f.Tag.Get("validate") != "" || findValidation(f.Type, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateStruct(m *validator, prefix string, v reflect.Value) {
+	t := v.Type()
+	info := getTypeInfo(t)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if r := info.relation(f.Name); r != nil && r.loaded {
+			continue
+		}
+
+		name := prefix + f.Name
+		if f.Anonymous {
+			name = strings.TrimSuffix(prefix, ".")
+		}
+		fv := v.Field(i)
+		if tag := f.Tag.Get("validate"); tag != "" {
+			validateField(m, name, fv, tag)
+		}
+		validateNested(m, name, fv, f.Anonymous)
+	}
+}
+
+func validateNested(m *validator, name string, v reflect.Value, anonymous bool) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(time.Time{}) {
+			return
+		}
+		prefix := name + "."
+		if anonymous {
+			prefix = name
+			if prefix != "" {
+				prefix += "."
+			}
+		}
+		validateStruct(m, prefix, v)
+	case reflect.Slice, reflect.Array:
+		if deref(v.Type().Elem()).Kind() != reflect.Struct {
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			validateNested(m, fmt.Sprintf("%s[%d]", name, i), v.Index(i), false)
+		}
+	}
+}
+
+func validateField(m *validator, name string, v reflect.Value, tag string) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if parseTag(tag).Has("required") {
+				m.AddErrorCode(name, CodeBlank, nil)
+			}
+			return
+		}
+		v = v.Elem()
+	}
+
+	for _, rule := range strings.Split(tag, ",") {
+		rule = strings.TrimSpace(rule)
+		key, arg := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			key, arg = rule[:i], rule[i+1:]
+		}
+
+		switch key {
+		case "required":
+			if v.IsZero() {
+				m.AddErrorCode(name, CodeBlank, nil)
+			}
+		case "min", "max":
+			validateRange(m, name, v, key, arg)
+		case "format":
+			if v.Kind() != reflect.String {
+				continue
+			}
+			switch arg {
+			case "url":
+				m.ValidateURL(name, v.String())
+			default:
+				if reg, ok := formats[arg]; ok {
+					arg = reg
+				}
+				m.ValidateFormat(name, v.String(), arg)
+			}
+		case "oneof":
+			s := fmt.Sprint(v.Interface())
+			if !contains(strings.Split(arg, "|"), s) {
+				m.AddErrorCode(name, CodeInclusion, nil)
+			}
+		case "":
+		default:
+			m.AddErrorCode(name, CodeUnknownRule, map[string]interface{}{"rule": key})
+		}
+	}
+}
+
+func validateRange(m *validator, name string, v reflect.Value, key, arg string) {
+	min, max := "-1", "-1"
+	if key == "min" {
+		min = arg
+	} else {
+		max = arg
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		m.ValidateLength(name, v.String(), atoi(min), atoi(max))
+	case reflect.Slice, reflect.Array, reflect.Map:
+		n := v.Len()
+		if key == "min" && n < atoi(arg) {
+			m.AddErrorCode(name, CodeTooShort, map[string]interface{}{"min": atoi(arg)})
+		}
+		if key == "max" && n > atoi(arg) {
+			m.AddErrorCode(name, CodeTooLong, map[string]interface{}{"max": atoi(arg)})
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		m.ValidateInt64(name, v.Int(), int64(atoi(min)), int64(atoi(max)))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		m.ValidateInt64(name, int64(v.Uint()), int64(atoi(min)), int64(atoi(max)))
+	case reflect.Float32, reflect.Float64:
+		a, _ := strconv.ParseFloat(min, 64)
+		b, _ := strconv.ParseFloat(max, 64)
+		m.ValidateFloat64(name, v.Float(), a, b)
+	}
+}
+
+func atoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
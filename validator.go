@@ -1,29 +1,42 @@
 package borm
 
 import (
-	"fmt"
+	"net/url"
+	"reflect"
 	"regexp"
+	"sort"
+	"sync"
+	"time"
 	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
 )
 
 // errors errors type
 type Errors map[string][]string
 
 type validator struct {
-	errors Errors
+	errors []FieldError
+}
+
+func (m *validator) getValidator() *validator {
+	return m
 }
 
 // ResetErrors clean all model errors
 func (m *validator) ResetErrors() {
-	m.errors = make(Errors)
+	m.errors = nil
 }
 
-// AddError adding error to record
+// AddError adding error with custom message to record
 func (m *validator) AddError(f string, t string) {
-	if m.Valid() {
-		m.errors = make(Errors)
-	}
-	m.errors[f] = append(m.errors[f], t)
+	m.errors = append(m.errors, FieldError{Field: f, Code: CodeCustom, Message: t})
+}
+
+// AddErrorCode adding error with code and params to record
+//  m.AddErrorCode("Name", borm.CodeTooShort, map[string]interface{}{"min": 3})
+func (m *validator) AddErrorCode(f string, code string, params map[string]interface{}) {
+	m.errors = append(m.errors, FieldError{Field: f, Code: code, Params: params})
 }
 
 // Valid returns true if no errors found in model
@@ -31,35 +44,62 @@ func (m *validator) Valid() bool {
 	return len(m.errors) == 0
 }
 
-// GetErrors returns record errors
+// GetErrors returns record errors messages formatted with Formatter
 func (m *validator) GetErrors() Errors {
+	return m.FormatErrors(Formatter)
+}
+
+// FormatErrors returns record errors messages formatted with f
+func (m *validator) FormatErrors(f MessageFormatter) Errors {
+	if len(m.errors) == 0 {
+		return nil
+	}
+	res := make(Errors)
+	for _, e := range m.errors {
+		res[e.Field] = append(res[e.Field], f(e))
+	}
+	return res
+}
+
+// FieldErrors returns record errors
+func (m *validator) FieldErrors() []FieldError {
 	return m.errors
 }
 
 // SetErrors set record errors
 func (m *validator) SetErrors(e Errors) {
-	m.errors = e
+	m.errors = nil
+	fields := make([]string, 0, len(e))
+	for f := range e {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	for _, f := range fields {
+		for _, t := range e[f] {
+			m.AddError(f, t)
+		}
+	}
 }
 
 // ValidatePresence validates string for presence
 //  m.ValidatePresence("Name", m.Name)
 func (m *validator) ValidatePresence(f, v string) {
 	if utf8.RuneCountInString(v) == 0 {
-		m.AddError(f, "can't be blank")
+		m.AddErrorCode(f, CodeBlank, nil)
 	}
 }
 
 // ValidateLength validates string min, max length. -1 for any
 //  m.ValidateLength("password", m.Password, 6, 18) // min 6, max 18
 func (m *validator) ValidateLength(f, v string, min, max int) {
-	if min > 0 {
+	if min >= 0 {
 		if utf8.RuneCountInString(v) < min {
-			m.AddError(f, fmt.Sprint("minimum length is", min))
+			m.AddErrorCode(f, CodeTooShort, map[string]interface{}{"min": min})
 		}
 	}
-	if max > 0 {
+	if max >= 0 {
 		if utf8.RuneCountInString(v) > max {
-			m.AddError(f, fmt.Sprint("maximum length is", max))
+			m.AddErrorCode(f, CodeTooLong, map[string]interface{}{"max": max})
 		}
 	}
 }
@@ -67,29 +107,20 @@ func (m *validator) ValidateLength(f, v string, min, max int) {
 // ValidateInt validates int min, max. -1 for any
 //  m.ValidateInt("number", 10, -1, 11)  // max 18
 func (m *validator) ValidateInt(f string, v, min, max int) {
-	if min > 0 {
-		if v < min {
-			m.AddError(f, fmt.Sprint("minimum length is", min))
-		}
-	}
-	if max > 0 {
-		if v > max {
-			m.AddError(f, fmt.Sprint("maximum length is", max))
-		}
-	}
+	m.ValidateInt64(f, int64(v), int64(min), int64(max))
 }
 
 // ValidateInt64 validates int64 min, max. -1 for any
 //  m.ValidateInt64("number", 10, 6, -1) // min 6
 func (m *validator) ValidateInt64(f string, v, min, max int64) {
-	if min > 0 {
+	if min != -1 {
 		if v < min {
-			m.AddError(f, fmt.Sprint("minimum length is", min))
+			m.AddErrorCode(f, CodeTooSmall, map[string]interface{}{"min": min})
 		}
 	}
-	if max > 0 {
+	if max != -1 {
 		if v > max {
-			m.AddError(f, fmt.Sprint("maximum length is", max))
+			m.AddErrorCode(f, CodeTooLarge, map[string]interface{}{"max": max})
 		}
 	}
 }
@@ -97,29 +128,20 @@ func (m *validator) ValidateInt64(f string, v, min, max int64) {
 // ValidateFloat32 validates float32 min, max. -1 for any
 //  m.ValidateFloat32("number", 10.2, -1, 11)
 func (m *validator) ValidateFloat32(f string, v, min, max float32) {
-	if min > 0 {
-		if v < min {
-			m.AddError(f, fmt.Sprint("minimum length is", min))
-		}
-	}
-	if max > 0 {
-		if v > max {
-			m.AddError(f, fmt.Sprint("maximum length is", max))
-		}
-	}
+	m.ValidateFloat64(f, float64(v), float64(min), float64(max))
 }
 
 // ValidateFloat64 validates float64 min, max. -1 for any
 //  m.ValidateFloat64("number", 10.2, -1, 11)
 func (m *validator) ValidateFloat64(f string, v, min, max float64) {
-	if min > 0 {
+	if min != -1 {
 		if v < min {
-			m.AddError(f, fmt.Sprint("minimum length is", min))
+			m.AddErrorCode(f, CodeTooSmall, map[string]interface{}{"min": min})
 		}
 	}
-	if max > 0 {
+	if max != -1 {
 		if v > max {
-			m.AddError(f, fmt.Sprint("maximum length is", max))
+			m.AddErrorCode(f, CodeTooLarge, map[string]interface{}{"max": max})
 		}
 	}
 }
@@ -127,7 +149,143 @@ func (m *validator) ValidateFloat64(f string, v, min, max float64) {
 // ValidateFormat validates string format with regex string
 //  m.ValidateFormat("ip address", u.IP, `\A(\d{1,3}\.){3}\d{1,3}\z`)
 func (m *validator) ValidateFormat(f, v, reg string) {
-	if r, _ := regexp.MatchString(reg, v); !r {
-		m.AddError(f, "invalid format")
+	if r, err := compileRegexp(reg); err != nil || !r.MatchString(v) {
+		m.AddErrorCode(f, CodeInvalidFormat, nil)
+	}
+}
+
+// ValidateEmail validates string is an email address
+//  m.ValidateEmail("Email", m.Email)
+func (m *validator) ValidateEmail(f, v string) {
+	m.ValidateFormat(f, v, emailFormat)
+}
+
+// ValidateURL validates string is an absolute URL
+//  m.ValidateURL("Homepage", m.Homepage)
+func (m *validator) ValidateURL(f, v string) {
+	u, err := url.ParseRequestURI(v)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		m.AddErrorCode(f, CodeInvalidFormat, nil)
+	}
+}
+
+// ValidateUUID validates string is an UUID
+//  m.ValidateUUID("Token", m.Token)
+func (m *validator) ValidateUUID(f, v string) {
+	m.ValidateFormat(f, v, uuidFormat)
+}
+
+// ValidateInclusion validates value is one of values
+//  m.ValidateInclusion("Status", m.Status, "new", "done")
+func (m *validator) ValidateInclusion(f string, v interface{}, values ...interface{}) {
+	if !includes(values, v) {
+		m.AddErrorCode(f, CodeInclusion, nil)
+	}
+}
+
+// ValidateExclusion validates value is none of values
+//  m.ValidateExclusion("Login", m.Login, "admin", "root")
+func (m *validator) ValidateExclusion(f string, v interface{}, values ...interface{}) {
+	if includes(values, v) {
+		m.AddErrorCode(f, CodeExclusion, nil)
+	}
+}
+
+// ValidateTime validates time min, max. zero time for any
+//  m.ValidateTime("Birthday", m.Birthday, time.Time{}, time.Now())
+func (m *validator) ValidateTime(f string, v, min, max time.Time) {
+	if !min.IsZero() && v.Before(min) {
+		m.AddErrorCode(f, CodeTooEarly, map[string]interface{}{"min": min.Format(time.RFC3339)})
+	}
+	if !max.IsZero() && v.After(max) {
+		m.AddErrorCode(f, CodeTooLate, map[string]interface{}{"max": max.Format(time.RFC3339)})
+	}
+}
+
+// ValidateConfirmation validates value equals its confirmation
+//  m.ValidateConfirmation("Password", m.Password, m.PasswordConfirmation)
+func (m *validator) ValidateConfirmation(f, v, confirmation string) {
+	if v != confirmation {
+		m.AddErrorCode(f, CodeConfirmation, nil)
+	}
+}
+
+// ValidateUniqueness validates no other record in bucket has field f
+// equal to v. Go field name f is mapped to storage name by Model of bucket
+// options, otherwise it's matched to stored fields case-insensitively
+//  m.ValidateUniqueness(db, []string{"people"}, "Email", m.Email)
+func (m *Model) ValidateUniqueness(db *DB, path []string, f string, v interface{}) {
+	path = db.scope(path)
+	if err := db.check(path); err != nil {
+		m.AddErrorCode(f, CodeUnverified, nil)
+		return
+	}
+
+	value, ok, err := encodedIndexValue(v)
+	if err != nil {
+		m.AddErrorCode(f, CodeUnverified, nil)
+		return
+	}
+	if !ok {
+		return
+	}
+	name := f
+	if info := db.bucketOptions(path).typeInfo(); info != nil {
+		name = info.storageName(f)
+	}
+
+	var ids []string
+	err = db.db.View(func(tx *bolt.Tx) error {
+		var err error
+		ids, err = db.findBy(tx, path, name, value)
+		return err
+	})
+	if err != nil {
+		m.AddErrorCode(f, CodeUnverified, nil)
+		return
+	}
+	for _, id := range ids {
+		if id != m.ID {
+			m.AddErrorCode(f, CodeTaken, nil)
+			return
+		}
+	}
+}
+
+const (
+	emailFormat = `\A[^@\s]+@[^@\s]+\.[^@\s]+\z`
+	uuidFormat  = `\A(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\z`
+)
+
+var regexps = struct {
+	sync.RWMutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+// compileRegexp returns compiled regex from cache
+func compileRegexp(reg string) (*regexp.Regexp, error) {
+	regexps.RLock()
+	r, ok := regexps.m[reg]
+	regexps.RUnlock()
+	if ok {
+		return r, nil
+	}
+
+	r, err := regexp.Compile(reg)
+	if err != nil {
+		return nil, err
+	}
+	regexps.Lock()
+	regexps.m[reg] = r
+	regexps.Unlock()
+	return r, nil
+}
+
+func includes(values []interface{}, v interface{}) bool {
+	for _, i := range values {
+		if reflect.DeepEqual(i, v) {
+			return true
+		}
 	}
+	return false
 }
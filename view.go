@@ -0,0 +1,275 @@
+package borm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+// viewsBucket is the root bucket holding materialized views
+const viewsBucket = "_views"
+
+// MapFunc emits view rows of record id decoded into rec. Keys must not
+// contain zero bytes. Encrypted fields are not passed in rec so they never
+// reach view rows
+// 		func(id string, rec map[string]interface{}, emit func(key string, value interface{})) {
+// 			emit(fmt.Sprint(rec["Status"]), 1)
+// 		}
+type MapFunc func(id string, rec map[string]interface{}, emit func(key string, value interface{}))
+
+// ViewRow is a row of materialized view emitted for record ID
+type ViewRow struct {
+	Key   string
+	ID    string
+	Value json.RawMessage
+}
+
+type view struct {
+	name string
+	// path is path of view bucket
+	path   []string
+	source []string
+	fn     MapFunc
+}
+
+type viewRegistry struct {
+	mu    sync.RWMutex
+	views map[string]*view
+}
+
+func newViewRegistry() *viewRegistry {
+	return &viewRegistry{views: make(map[string]*view)}
+}
+
+// DefineView defines materialized view of bucket sourcePath. Rows emitted by
+// fn are updated in the same transaction as records of bucket. Definitions are
+// not stored so view is rebuilt on every DefineView
+// 		db.DefineView("orders_by_status", []string{"orders"}, func(id string, rec map[string]interface{}, emit func(string, interface{})) {
+// 			emit(fmt.Sprint(rec["Status"]), rec["Amount"])
+// 		})
+func (db *DB) DefineView(name string, sourcePath []string, fn MapFunc) error {
+	sourcePath = db.scope(sourcePath)
+	l := logit(db.Log, "DEFINE-VIEW", sourcePath, name, nil)
+	err := db.defineView(name, sourcePath, fn)
+	return l.done(err)
+}
+
+func (db *DB) defineView(name string, sourcePath []string, fn MapFunc) error {
+	if err := db.check(sourcePath); err != nil {
+		return err
+	}
+	if db.bucketOptions(sourcePath).Encrypt {
+		return encryptedDerived(sourcePath, "views")
+	}
+	if db.views == nil {
+		db.views = newViewRegistry()
+	}
+	v := &view{name: name, path: db.viewPath(name), source: sourcePath, fn: fn}
+	db.views.mu.Lock()
+	db.views.views[pathKey(v.path)] = v
+	db.views.mu.Unlock()
+
+	return db.db.Update(func(tx *bolt.Tx) error {
+		return db.buildView(tx, v)
+	})
+}
+
+// RebuildView rebuilds rows of view from records of its bucket
+func (db *DB) RebuildView(name string) error {
+	l := logit(db.Log, "REBUILD-VIEW", db.viewPath(name), name, nil)
+	err := db.rebuildView(name)
+	return l.done(err)
+}
+
+func (db *DB) rebuildView(name string) error {
+	v := db.getView(name)
+	if v == nil {
+		return fmt.Errorf("View %s not found", name)
+	}
+
+	return db.db.Update(func(tx *bolt.Tx) error {
+		return db.buildView(tx, v)
+	})
+}
+
+// QueryView returns rows of view ordered by key
+// 		rows, err := db.QueryView("orders_by_status", borm.Params{Limit: 10})
+func (db *DB) QueryView(name string, params ...Params) ([]ViewRow, error) {
+	l := logit(db.Log, "QUERY-VIEW", db.viewPath(name), name, params)
+	res, err := db.queryView(name, params...)
+	return res, l.done(err)
+}
+
+func (db *DB) queryView(name string, params ...Params) (res []ViewRow, err error) {
+	opts := parseParams(params)
+	err = db.db.View(func(tx *bolt.Tx) error {
+		b := getBucket(tx, db.viewPath(name))
+		if b == nil {
+			return fmt.Errorf("View %s not found", name)
+		}
+		i := 0
+		c := b.Cursor()
+		for k, v := cursorStart(c, opts.Reverse); k != nil && len(res) < opts.Limit; k, v = cursorNext(c, opts.Reverse) {
+			if i++; i <= opts.Offset {
+				continue
+			}
+			n := bytes.IndexByte(k, 0)
+			if n < 0 {
+				return errors.New("Invalid view key")
+			}
+			res = append(res, ViewRow{
+				Key:   string(k[:n]),
+				ID:    string(k[n+1:]),
+				Value: append(json.RawMessage{}, v...),
+			})
+		}
+		return nil
+	})
+	return
+}
+
+func (db *DB) getView(name string) *view {
+	if db.views == nil {
+		return nil
+	}
+	db.views.mu.RLock()
+	defer db.views.mu.RUnlock()
+	return db.views.views[pathKey(db.viewPath(name))]
+}
+
+// viewPath returns path of view bucket
+func (db *DB) viewPath(name string) []string {
+	return append(append([]string{}, db.prefix...), viewsBucket, name)
+}
+
+// buildView builds view rows from all records of its bucket
+func (db *DB) buildView(tx *bolt.Tx, v *view) error {
+	root, err := createBucket(tx, v.path[:len(v.path)-1])
+	if err != nil {
+		return fmt.Errorf("create bucket: %s", err)
+	}
+	if root.Bucket([]byte(v.name)) != nil {
+		if err := root.DeleteBucket([]byte(v.name)); err != nil {
+			return err
+		}
+	}
+	vb, err := root.CreateBucket([]byte(v.name))
+	if err != nil {
+		return fmt.Errorf("create bucket: %s", err)
+	}
+
+	b := getBucket(tx, v.source)
+	if b == nil {
+		return nil
+	}
+	sealed, err := loadEncrypted(tx, v.source)
+	if err != nil {
+		return err
+	}
+	return b.ForEach(func(k, val []byte) error {
+		if val == nil {
+			return nil
+		}
+		val, err := db.unpack(v.source, val)
+		if err != nil {
+			return err
+		}
+		return v.apply(vb, string(k), val, sealed, true)
+	})
+}
+
+// updateViews updates views of bucket for record id changed from old to enc.
+// nil old means new record, nil enc means deleted record
+func (db *DB) updateViews(tx *bolt.Tx, path []string, id string, old, enc []byte) error {
+	views := db.sourceViews(path)
+	if len(views) == 0 {
+		return nil
+	}
+
+	sealed, err := loadEncrypted(tx, path)
+	if err != nil {
+		return err
+	}
+	for _, v := range views {
+		vb, err := createBucket(tx, v.path)
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		if err := v.apply(vb, id, old, sealed, false); err != nil {
+			return err
+		}
+		if err := v.apply(vb, id, enc, sealed, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sourceViews returns views of bucket path
+func (db *DB) sourceViews(path []string) (res []*view) {
+	if db.views == nil {
+		return
+	}
+	db.views.mu.RLock()
+	defer db.views.mu.RUnlock()
+	for _, v := range db.views.views {
+		if pathKey(v.source) == pathKey(path) {
+			res = append(res, v)
+		}
+	}
+	return
+}
+
+// apply puts or deletes rows emitted for record enc into view bucket b.
+// Encrypted fields sealed are removed from record passed to map function
+func (v *view) apply(b *bolt.Bucket, id string, enc []byte, sealed []string, put bool) error {
+	if enc == nil {
+		return nil
+	}
+	rec, err := unmarshalMap(enc)
+	if err != nil {
+		return err
+	}
+	for _, f := range sealed {
+		delete(rec, f)
+	}
+
+	var rows [][2][]byte
+	v.fn(id, rec, func(key string, value interface{}) {
+		if err != nil {
+			return
+		}
+		if strings.IndexByte(key, 0) >= 0 {
+			err = fmt.Errorf("invalid key %q: keys must not contain zero bytes", key)
+			return
+		}
+		var val []byte
+		if val, err = marshal(value); err == nil {
+			rows = append(rows, [2][]byte{viewKey(key, id), val})
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("view %s: %s", v.name, err)
+	}
+
+	for _, r := range rows {
+		if !put {
+			err = b.Delete(r[0])
+		} else {
+			err = b.Put(r[0], r[1])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func viewKey(key, id string) []byte {
+	return append(append([]byte(key), 0), id...)
+}